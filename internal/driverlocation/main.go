@@ -3,16 +3,26 @@ package main
 import (
 	"context"
 	"os"
+	"sync"
 
 	"github.com/s3f4/locationmatcher/internal/driverlocation/repository"
 	"github.com/s3f4/locationmatcher/internal/driverlocation/server"
 	"github.com/s3f4/locationmatcher/pkg/log"
+	"github.com/s3f4/locationmatcher/pkg/shutdown"
+	"github.com/s3f4/locationmatcher/pkg/tracing"
 )
 
 func main() {
-	ctx, cancel := context.WithCancel(context.Background())
+	// ctx is canceled on SIGINT and SIGTERM
+	ctx, cancel := shutdown.NotifyContext(context.Background())
 	defer cancel()
 
+	// exports spans if OTEL_TRACES_EXPORTER is set
+	shutdownTracing, err := tracing.Init(ctx, "driverlocation")
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	connClientMap := repository.InitConnecions()
 
 	repoType := os.Getenv("REPOSITORY")
@@ -20,16 +30,37 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	repo = repository.Instrument(repoType, repo)
 
 	if os.Getenv("MIGRATE") == "true" {
-		repo.Migrate(ctx)
+		if err := repo.Migrate(ctx); err != nil {
+			log.Fatal(err)
+		}
 	}
 
+	// removes stale driver locations if DRIVER_LOCATION_MAX_AGE is set
+	var sweeping sync.WaitGroup
+	sweeping.Add(1)
+	go func() {
+		defer sweeping.Done()
+		repository.Sweep(ctx, repo)
+	}()
+
 	server, err := server.NewServer(os.Getenv("SERVER"))
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Starts server
+	// Starts server, it returns after in-flight requests and migrations are done
 	server.Start(ctx, repo)
+
+	sweeping.Wait()
+
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), shutdown.Timeout)
+	defer closeCancel()
+	repository.CloseConnections(closeCtx, connClientMap)
+
+	if err := shutdownTracing(closeCtx); err != nil {
+		log.Error(err)
+	}
 }
@@ -14,6 +14,20 @@ type Repository struct {
 	mock.Mock
 }
 
+// CheckIndex provides a mock function with given fields: _a0
+func (_m *Repository) CheckIndex(_a0 context.Context) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // CreateIndex provides a mock function with given fields: _a0, _a1, _a2
 func (_m *Repository) CreateIndex(_a0 context.Context, _a1 string, _a2 string) error {
 	ret := _m.Called(_a0, _a1, _a2)
@@ -89,20 +103,52 @@ func (_m *Repository) Find1(_a0 context.Context, _a1 *models.Query) ([]*models.D
 }
 
 // Migrate provides a mock function with given fields: _a0
-func (_m *Repository) Migrate(_a0 context.Context) {
-	_m.Called(_a0)
+func (_m *Repository) Migrate(_a0 context.Context) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
 }
 
-// UpsertBulk provides a mock function with given fields: _a0, _a1
-func (_m *Repository) UpsertBulk(_a0 context.Context, _a1 []*models.DriverLocation) error {
-	ret := _m.Called(_a0, _a1)
+// Ping provides a mock function with given fields: _a0
+func (_m *Repository) Ping(_a0 context.Context) error {
+	ret := _m.Called(_a0)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, []*models.DriverLocation) error); ok {
-		r0 = rf(_a0, _a1)
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(_a0)
 	} else {
 		r0 = ret.Error(0)
 	}
 
 	return r0
 }
+
+// UpsertBulk provides a mock function with given fields: _a0, _a1
+func (_m *Repository) UpsertBulk(_a0 context.Context, _a1 []*models.DriverLocation) ([]*models.UpsertResult, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 []*models.UpsertResult
+	if rf, ok := ret.Get(0).(func(context.Context, []*models.DriverLocation) []*models.UpsertResult); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*models.UpsertResult)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []*models.DriverLocation) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
@@ -3,20 +3,64 @@ package models
 import (
 	"fmt"
 	"math"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-var ErrInvalidCoordinates = fmt.Errorf("invalid coordinates")
+var (
+	ErrInvalidCoordinates = fmt.Errorf("invalid coordinates")
+	ErrInvalidStatus      = fmt.Errorf("invalid driver status")
+)
+
+// DriverStatus is the availability of a driver
+type DriverStatus string
+
+const (
+	StatusAvailable DriverStatus = "available"
+	StatusBusy      DriverStatus = "busy"
+	StatusOffline   DriverStatus = "offline"
+)
+
+// Valid reports whether the status is one of the known statuses.
+func (s DriverStatus) Valid() bool {
+	switch s {
+	case StatusAvailable, StatusBusy, StatusOffline:
+		return true
+	}
+	return false
+}
 
 // DriverLocation holds the driver's location data
 type DriverLocation struct {
 	ID            primitive.ObjectID `json:"_id" bson:"_id"`
+	DriverID      string             `json:"driver_id,omitempty" bson:"driver_id,omitempty"`
+	Status        DriverStatus       `json:"status,omitempty" bson:"status,omitempty"`
+	UpdatedAt     time.Time          `json:"updated_at" bson:"updated_at"`
 	Location      Location           `json:"location" bson:"location"`
 	Distance      float64            `json:"distance" bson:"-"`
 	MongoDistance *float64           `json:"mongo_distance,omitempty" bson:"mongo_distance,omitempty"`
 }
 
+// Touch sets the update time of the driver location
+func (driverLocation *DriverLocation) Touch(now time.Time) {
+	driverLocation.UpdatedAt = now
+}
+
+// KeepStatus sets an empty status to the stored status of the driver
+// location, new driver locations without a status are stored as available.
+// stored is empty for new driver locations.
+func (driverLocation *DriverLocation) KeepStatus(stored DriverStatus) {
+	if driverLocation.Status != "" {
+		return
+	}
+
+	driverLocation.Status = stored
+	if driverLocation.Status == "" {
+		driverLocation.Status = StatusAvailable
+	}
+}
+
 func toRad(degrees float64) float64 {
 	return degrees * math.Pi / 180
 }
@@ -48,6 +92,11 @@ func (driverLocation *DriverLocation) CalculateDistance(latitude, longitude floa
 	return d, nil
 }
 
+// Coordinates returns the [longitude, latitude] pair of the driver location.
+func (driverLocation *DriverLocation) Coordinates() ([]float64, error) {
+	return driverLocation.getLocation()
+}
+
 // getLocation converts the coordinates of the driver location appropriate values.
 func (driverLocation *DriverLocation) getLocation() ([]float64, error) {
 	coords := make([]float64, 2)
@@ -99,5 +148,10 @@ func (driverLocation *DriverLocation) Validate() error {
 		return fmt.Errorf("provide a valid latitude value")
 	}
 
+	// empty status is allowed, it is stored as available
+	if driverLocation.Status != "" && !driverLocation.Status.Valid() {
+		return ErrInvalidStatus
+	}
+
 	return nil
 }
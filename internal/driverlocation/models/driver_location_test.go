@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -156,4 +157,44 @@ func Test_Validate(t *testing.T) {
 			t.Error("Coordinate error")
 		}
 	}
+
+	driverLocation.Location.Coordinates = []float64{40.94, 29.1}
+	driverLocation.Status = "sleeping"
+	if err := driverLocation.Validate(); err != ErrInvalidStatus {
+		t.Error("Status error")
+	}
+
+	driverLocation.Status = StatusBusy
+	if err := driverLocation.Validate(); err != nil {
+		t.Error("It must not return an error")
+	}
+}
+
+func Test_Touch(t *testing.T) {
+	now := time.Now()
+	driverLocation := DriverLocation{}
+	driverLocation.Touch(now)
+	if driverLocation.Status != "" || !driverLocation.UpdatedAt.Equal(now) {
+		t.Error("Touch must only set the update time")
+	}
+}
+
+func Test_KeepStatus(t *testing.T) {
+	driverLocation := DriverLocation{}
+	driverLocation.KeepStatus("")
+	if driverLocation.Status != StatusAvailable {
+		t.Error("New driver locations without a status must be available")
+	}
+
+	driverLocation.Status = ""
+	driverLocation.KeepStatus(StatusBusy)
+	if driverLocation.Status != StatusBusy {
+		t.Error("KeepStatus must keep the stored status")
+	}
+
+	driverLocation.Status = StatusOffline
+	driverLocation.KeepStatus(StatusBusy)
+	if driverLocation.Status != StatusOffline {
+		t.Error("KeepStatus must not change the status")
+	}
 }
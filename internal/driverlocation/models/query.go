@@ -4,10 +4,19 @@ import (
 	"fmt"
 )
 
+// MaxLimit is the maximum number of driver locations returned by a query
+const MaxLimit = 1000
+
 type Query struct {
 	Location    Location `json:"location"`
 	MinDistance int64    `json:"minDistance"`
 	MaxDistance int64    `json:"maxDistance"`
+	// Limit is the number of nearest driver locations to return, 0 means no limit
+	Limit int64 `json:"limit,omitempty"`
+	// Offset is the number of nearest driver locations to skip
+	Offset int64 `json:"offset,omitempty"`
+	// Status filters driver locations by driver status, empty returns all
+	Status DriverStatus `json:"status,omitempty"`
 }
 
 func (q Query) Validate() error {
@@ -47,5 +56,17 @@ func (q Query) Validate() error {
 		return fmt.Errorf("maxDistance must be greater then 0 and minDistance")
 	}
 
+	if q.Limit < 0 || q.Limit > MaxLimit {
+		return fmt.Errorf("limit must be between 0 and %d", MaxLimit)
+	}
+
+	if q.Offset < 0 {
+		return fmt.Errorf("offset must not be negative")
+	}
+
+	if q.Status != "" && !q.Status.Valid() {
+		return ErrInvalidStatus
+	}
+
 	return nil
 }
@@ -118,6 +118,72 @@ func Test_Query(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "it should return an error if limit is negative",
+			query: Query{
+				Location: Location{
+					Type:        "Point",
+					Coordinates: []interface{}{10.1, 10.0},
+				},
+				MinDistance: 0,
+				MaxDistance: 10,
+				Limit:       -1,
+			},
+			wantErr: true,
+		},
+		{
+			name: "it should return an error if limit is greater than MaxLimit",
+			query: Query{
+				Location: Location{
+					Type:        "Point",
+					Coordinates: []interface{}{10.1, 10.0},
+				},
+				MinDistance: 0,
+				MaxDistance: 10,
+				Limit:       MaxLimit + 1,
+			},
+			wantErr: true,
+		},
+		{
+			name: "it should return an error if offset is negative",
+			query: Query{
+				Location: Location{
+					Type:        "Point",
+					Coordinates: []interface{}{10.1, 10.0},
+				},
+				MinDistance: 0,
+				MaxDistance: 10,
+				Offset:      -1,
+			},
+			wantErr: true,
+		},
+		{
+			name: "it should return an error if status is not valid",
+			query: Query{
+				Location: Location{
+					Type:        "Point",
+					Coordinates: []interface{}{10.1, 10.0},
+				},
+				MinDistance: 0,
+				MaxDistance: 10,
+				Status:      "sleeping",
+			},
+			wantErr: true,
+		},
+		{
+			name: "it shouldn't return an error with limit and offset",
+			query: Query{
+				Location: Location{
+					Type:        "Point",
+					Coordinates: []interface{}{10.1, 10.0},
+				},
+				MinDistance: 0,
+				MaxDistance: 10,
+				Limit:       10,
+				Offset:      20,
+			},
+			wantErr: false,
+		},
 		{
 			name: "it shouldn't return an error ",
 			query: Query{
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UpsertStatus is the outcome of upserting a driver location
+type UpsertStatus string
+
+const (
+	UpsertInserted UpsertStatus = "inserted"
+	UpsertUpdated  UpsertStatus = "updated"
+	UpsertFailed   UpsertStatus = "failed"
+)
+
+// UpsertResult is the result of upserting one driver location of a bulk,
+// results are in the same order as the driver locations.
+type UpsertResult struct {
+	ID       primitive.ObjectID `json:"_id"`
+	DriverID string             `json:"driver_id,omitempty"`
+	Status   UpsertStatus       `json:"status"`
+	Error    string             `json:"error,omitempty"`
+}
+
+// NewUpsertResult returns the result of the given driver location
+func NewUpsertResult(driverLocation *DriverLocation, status UpsertStatus, err error) *UpsertResult {
+	result := &UpsertResult{
+		ID:       driverLocation.ID,
+		DriverID: driverLocation.DriverID,
+		Status:   status,
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	return result
+}
@@ -0,0 +1,149 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.19.4
+// source: driver_location.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// DriverLocationServiceClient is the client API for DriverLocationService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DriverLocationServiceClient interface {
+	// UpsertBulk creates or updates the given driver locations by driver id,
+	// or by id if the driver id is empty.
+	UpsertBulk(ctx context.Context, in *UpsertBulkRequest, opts ...grpc.CallOption) (*UpsertBulkResponse, error)
+	// FindNearest returns driver locations within the given distance range
+	// ordered by distance.
+	FindNearest(ctx context.Context, in *FindNearestRequest, opts ...grpc.CallOption) (*FindNearestResponse, error)
+}
+
+type driverLocationServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDriverLocationServiceClient(cc grpc.ClientConnInterface) DriverLocationServiceClient {
+	return &driverLocationServiceClient{cc}
+}
+
+func (c *driverLocationServiceClient) UpsertBulk(ctx context.Context, in *UpsertBulkRequest, opts ...grpc.CallOption) (*UpsertBulkResponse, error) {
+	out := new(UpsertBulkResponse)
+	err := c.cc.Invoke(ctx, "/driverlocation.v1.DriverLocationService/UpsertBulk", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *driverLocationServiceClient) FindNearest(ctx context.Context, in *FindNearestRequest, opts ...grpc.CallOption) (*FindNearestResponse, error) {
+	out := new(FindNearestResponse)
+	err := c.cc.Invoke(ctx, "/driverlocation.v1.DriverLocationService/FindNearest", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DriverLocationServiceServer is the server API for DriverLocationService service.
+// All implementations must embed UnimplementedDriverLocationServiceServer
+// for forward compatibility
+type DriverLocationServiceServer interface {
+	// UpsertBulk creates or updates the given driver locations by driver id,
+	// or by id if the driver id is empty.
+	UpsertBulk(context.Context, *UpsertBulkRequest) (*UpsertBulkResponse, error)
+	// FindNearest returns driver locations within the given distance range
+	// ordered by distance.
+	FindNearest(context.Context, *FindNearestRequest) (*FindNearestResponse, error)
+	mustEmbedUnimplementedDriverLocationServiceServer()
+}
+
+// UnimplementedDriverLocationServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedDriverLocationServiceServer struct {
+}
+
+func (UnimplementedDriverLocationServiceServer) UpsertBulk(context.Context, *UpsertBulkRequest) (*UpsertBulkResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpsertBulk not implemented")
+}
+func (UnimplementedDriverLocationServiceServer) FindNearest(context.Context, *FindNearestRequest) (*FindNearestResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FindNearest not implemented")
+}
+func (UnimplementedDriverLocationServiceServer) mustEmbedUnimplementedDriverLocationServiceServer() {}
+
+// UnsafeDriverLocationServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DriverLocationServiceServer will
+// result in compilation errors.
+type UnsafeDriverLocationServiceServer interface {
+	mustEmbedUnimplementedDriverLocationServiceServer()
+}
+
+func RegisterDriverLocationServiceServer(s grpc.ServiceRegistrar, srv DriverLocationServiceServer) {
+	s.RegisterService(&DriverLocationService_ServiceDesc, srv)
+}
+
+func _DriverLocationService_UpsertBulk_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpsertBulkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DriverLocationServiceServer).UpsertBulk(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/driverlocation.v1.DriverLocationService/UpsertBulk",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DriverLocationServiceServer).UpsertBulk(ctx, req.(*UpsertBulkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DriverLocationService_FindNearest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FindNearestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DriverLocationServiceServer).FindNearest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/driverlocation.v1.DriverLocationService/FindNearest",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DriverLocationServiceServer).FindNearest(ctx, req.(*FindNearestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// DriverLocationService_ServiceDesc is the grpc.ServiceDesc for DriverLocationService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var DriverLocationService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "driverlocation.v1.DriverLocationService",
+	HandlerType: (*DriverLocationServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "UpsertBulk",
+			Handler:    _DriverLocationService_UpsertBulk_Handler,
+		},
+		{
+			MethodName: "FindNearest",
+			Handler:    _DriverLocationService_FindNearest_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "driver_location.proto",
+}
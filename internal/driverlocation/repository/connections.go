@@ -2,8 +2,13 @@ package repository
 
 import (
 	"context"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/s3f4/locationmatcher/pkg/log"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -12,6 +17,9 @@ import (
 const (
 	mongoKey   = "mongo"
 	elasticKey = "elastic"
+	memoryKey  = "memory"
+	redisKey   = "redis"
+	postgisKey = "postgis"
 )
 
 // mongoClient is used to connect mongodb, connection will be done one time.
@@ -33,16 +41,116 @@ func connectMongo(dsn string) (*mongo.Client, error) {
 	return client, nil
 }
 
-// InitConnecions starts all connections
+// connectElastic creates an elasticsearch client with the given url
+// and checks the connection.
+func connectElastic(url string) (*elasticClient, error) {
+	client := &elasticClient{
+		url: strings.TrimSuffix(url, "/"),
+		client: &http.Client{
+			Timeout: time.Second * 15,
+		},
+	}
+
+	// Check the connection
+	resp, err := client.do(context.Background(), http.MethodGet, "/", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	return client, nil
+}
+
+// connectRedis connects redis with redis url, e.g. redis://localhost:6379/0
+func connectRedis(url string) (*redis.Client, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(opts)
+	// Check the connection
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// connectPostgis connects postgres with postgres dsn string
+func connectPostgis(dsn string) (*pgxpool.Pool, error) {
+	ctx := context.Background()
+	pool, err := pgxpool.Connect(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+	// Check the connection
+	if err := pool.Ping(ctx); err != nil {
+		return nil, err
+	}
+
+	return pool, nil
+}
+
+// InitConnecions starts connections of the configured backends
 func InitConnecions() map[string]interface{} {
 	clientMap := map[string]interface{}{}
 
-	var err error
-	mongoClient, err = connectMongo(os.Getenv("MONGO_DSN"))
-	if err != nil {
-		log.Fatal(err)
+	if dsn := os.Getenv("MONGO_DSN"); dsn != "" {
+		var err error
+		mongoClient, err = connectMongo(dsn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		clientMap[mongoKey] = mongoClient
+	}
+
+	if url := os.Getenv("ELASTIC_URL"); url != "" {
+		elasticClient, err := connectElastic(url)
+		if err != nil {
+			log.Fatal(err)
+		}
+		clientMap[elasticKey] = elasticClient
+	}
+
+	if url := os.Getenv("REDIS_URL"); url != "" {
+		redisClient, err := connectRedis(url)
+		if err != nil {
+			log.Fatal(err)
+		}
+		clientMap[redisKey] = redisClient
+	}
+
+	if dsn := os.Getenv("POSTGRES_DSN"); dsn != "" {
+		pool, err := connectPostgis(dsn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		clientMap[postgisKey] = pool
 	}
 
-	clientMap[mongoKey] = mongoClient
 	return clientMap
 }
+
+// CloseConnections closes the connections started by InitConnecions
+func CloseConnections(ctx context.Context, clientMap map[string]interface{}) {
+	for key, client := range clientMap {
+		var err error
+		switch c := client.(type) {
+		case *mongo.Client:
+			err = c.Disconnect(ctx)
+		case *redis.Client:
+			err = c.Close()
+		case *pgxpool.Pool:
+			c.Close()
+		case *elasticClient:
+			c.client.CloseIdleConnections()
+		}
+
+		if err != nil {
+			log.Errorf("%s connection close err: %s", key, err)
+			continue
+		}
+		log.Infof("%s connection closed", key)
+	}
+}
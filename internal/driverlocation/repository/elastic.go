@@ -1,34 +1,560 @@
 package repository
 
 import (
+	"bytes"
 	"context"
-	"log"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
 
 	"github.com/s3f4/locationmatcher/internal/driverlocation/models"
+	"github.com/s3f4/locationmatcher/pkg/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-type elasticRepository struct{}
+var (
+	// Index is the elasticsearch index of driver locations
+	Index = os.Getenv("DRIVER_LOCATION_INDEX")
+)
+
+const (
+	// elasticMaxResults is the default index.max_result_window of elasticsearch,
+	// from + size of a search can not exceed it
+	elasticMaxResults int64 = 10000
+)
+
+type elasticRepository struct {
+	client *elasticClient
+}
+
+// elasticDocument is the indexed form of a driver location, location is
+// stored as GeoJSON which is accepted by the geo_point type.
+type elasticDocument struct {
+	DriverID  string              `json:"driver_id,omitempty"`
+	Status    models.DriverStatus `json:"status,omitempty"`
+	UpdatedAt time.Time           `json:"updated_at"`
+	Location  models.Location     `json:"location"`
+}
+
+// elasticMappings are the field types of the index besides location
+var elasticMappings = map[string]string{
+	"driver_id":  "keyword",
+	"status":     "keyword",
+	"updated_at": "date",
+}
+
+// elasticUpdate updates a document with doc or inserts upsert
+type elasticUpdate struct {
+	Doc    elasticDocument `json:"doc"`
+	Upsert elasticDocument `json:"upsert"`
+}
+
+type elasticHit struct {
+	ID     string          `json:"_id"`
+	Source elasticDocument `json:"_source"`
+	Sort   []float64       `json:"sort"`
+}
+
+type elasticSearchResponse struct {
+	Hits struct {
+		Hits []elasticHit `json:"hits"`
+	} `json:"hits"`
+}
+
+type elasticBulkResponse struct {
+	Errors bool                         `json:"errors"`
+	Items  []map[string]elasticBulkItem `json:"items"`
+}
+
+// elasticBulkItem is the result of one action of a bulk request,
+// result is created, updated or noop for successful update actions.
+type elasticBulkItem struct {
+	ID     string `json:"_id"`
+	Status int    `json:"status"`
+	Result string `json:"result,omitempty"`
+	Error  *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error,omitempty"`
+}
+
+// Find returns driver locations between min and max distance sorted by distance.
+func (r *elasticRepository) Find(ctx context.Context, query *models.Query) ([]*models.DriverLocation, error) {
+	return r.search(ctx, query, query.MinDistance, false)
+}
+
+// Find1 returns driver locations within max distance sorted by distance,
+// MongoDistance holds the distance calculated by elasticsearch in kilometers
+// like the $geoNear stage of mongoRepository.
+func (r *elasticRepository) Find1(ctx context.Context, query *models.Query) ([]*models.DriverLocation, error) {
+	return r.search(ctx, query, 0, true)
+}
+
+func (r *elasticRepository) search(ctx context.Context, query *models.Query, minDistance int64, setDistance bool) ([]*models.DriverLocation, error) {
+	coords, _ := query.Location.Coordinates.([]interface{})
+	point := map[string]interface{}{
+		"lon": coords[0],
+		"lat": coords[1],
+	}
+
+	filters := []interface{}{
+		map[string]interface{}{
+			"geo_distance": map[string]interface{}{
+				"distance": fmt.Sprintf("%dm", query.MaxDistance),
+				"location": point,
+			},
+		},
+	}
+
+	if query.Status != "" {
+		filters = append(filters, map[string]interface{}{
+			"term": map[string]interface{}{"status": query.Status},
+		})
+	}
+
+	if before := expiredBefore(time.Now()); !before.IsZero() {
+		filters = append(filters, map[string]interface{}{
+			"range": map[string]interface{}{
+				"updated_at": map[string]interface{}{"gte": before},
+			},
+		})
+	}
+
+	boolQuery := map[string]interface{}{
+		"filter": filters,
+	}
+
+	if minDistance > 0 {
+		boolQuery["must_not"] = map[string]interface{}{
+			"geo_distance": map[string]interface{}{
+				"distance": fmt.Sprintf("%dm", minDistance),
+				"location": point,
+			},
+		}
+	}
+
+	size := elasticMaxResults - query.Offset
+	if query.Limit > 0 && query.Limit < size {
+		size = query.Limit
+	}
+
+	if size <= 0 {
+		return []*models.DriverLocation{}, nil
+	}
+
+	body := map[string]interface{}{
+		"from":  query.Offset,
+		"size":  size,
+		"query": map[string]interface{}{"bool": boolQuery},
+		"sort": []interface{}{
+			map[string]interface{}{
+				"_geo_distance": map[string]interface{}{
+					"location":      point,
+					"order":         "asc",
+					"unit":          "m",
+					"distance_type": "arc",
+				},
+			},
+		},
+	}
+
+	var response elasticSearchResponse
+	if err := r.client.doJSON(ctx, http.MethodPost, "/"+Index+"/_search", body, &response); err != nil {
+		return nil, err
+	}
+
+	driverLocations := []*models.DriverLocation{}
+	for _, hit := range response.Hits.Hits {
+		driverLocation := &models.DriverLocation{
+			DriverID:  hit.Source.DriverID,
+			Status:    hit.Source.Status,
+			UpdatedAt: hit.Source.UpdatedAt,
+			Location:  hit.Source.Location,
+		}
+
+		id, err := primitive.ObjectIDFromHex(hit.ID)
+		if err != nil {
+			log.Error("Could not decode driver location id")
+			return nil, err
+		}
+		driverLocation.ID = id
+
+		if setDistance && len(hit.Sort) > 0 {
+			eDistance := hit.Sort[0] / 1000
+			driverLocation.MongoDistance = &eDistance
+		}
+
+		driverLocation.Distance, err = driverLocation.CalculateDistance(
+			coords[1].(float64),
+			coords[0].(float64),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		driverLocations = append(driverLocations, driverLocation)
+	}
+
+	return driverLocations, nil
+}
+
+// UpsertBulk indexes driver locations with the bulk api. Documents of
+// drivers are keyed by an id derived from the driver id, so concurrent
+// upserts of a driver update the same document, other documents are keyed by
+// the id of the driver location. Driver locations with invalid coordinates,
+// with the id of another driver or with item errors fail.
+func (r *elasticRepository) UpsertBulk(ctx context.Context, driverLocations []*models.DriverLocation) ([]*models.UpsertResult, error) {
+	results := make([]*models.UpsertResult, len(driverLocations))
+	valid := make([]int, 0, len(driverLocations))
+	for i, driverLocation := range driverLocations {
+		if _, err := driverLocation.Coordinates(); err != nil {
+			results[i] = models.NewUpsertResult(driverLocation, models.UpsertFailed, err)
+			continue
+		}
+		valid = append(valid, i)
+	}
+
+	valid, err := r.resolveIDs(ctx, driverLocations, valid, results)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	now := time.Now().UTC()
+	for _, i := range valid {
+		driverLocation := driverLocations[i]
+		driverLocation.Touch(now)
+
+		action := map[string]interface{}{
+			"update": map[string]interface{}{"_id": driverLocation.ID.Hex()},
+		}
+		if err := encoder.Encode(action); err != nil {
+			return nil, err
+		}
+
+		// an empty status is omitted from the update and keeps the stored
+		// status, new drivers are available
+		document := elasticDocument{
+			DriverID:  driverLocation.DriverID,
+			Status:    driverLocation.Status,
+			UpdatedAt: driverLocation.UpdatedAt,
+			Location:  driverLocation.Location,
+		}
+		inserted := document
+		if inserted.Status == "" {
+			inserted.Status = models.StatusAvailable
+		}
+		if err := encoder.Encode(elasticUpdate{Doc: document, Upsert: inserted}); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := r.client.do(ctx, http.MethodPost, "/"+Index+"/_bulk", "application/x-ndjson", &buf)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
 
-func (r *elasticRepository) UpsertBulk(context.Context, []*models.DriverLocation) error {
-	return ErrNotImplemented
+	var response elasticBulkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	if len(response.Items) != len(valid) {
+		return nil, fmt.Errorf("elastic bulk error: %d items for %d actions", len(response.Items), len(valid))
+	}
+
+	for j, i := range valid {
+		item := response.Items[j]["update"]
+		switch {
+		case item.Error != nil:
+			err := fmt.Errorf("elastic bulk error %s: %s", item.Error.Type, item.Error.Reason)
+			results[i] = models.NewUpsertResult(driverLocations[i], models.UpsertFailed, err)
+		case item.Result == "created":
+			results[i] = models.NewUpsertResult(driverLocations[i], models.UpsertInserted, nil)
+		default:
+			results[i] = models.NewUpsertResult(driverLocations[i], models.UpsertUpdated, nil)
+		}
+	}
+
+	return results, nil
+}
+
+// elasticDriverID returns the document id of a driver
+func elasticDriverID(driverID string) primitive.ObjectID {
+	var id primitive.ObjectID
+	digest := sha256.Sum256([]byte(driverID))
+	copy(id[:], digest[:])
+	return id
+}
+
+// resolveIDs sets the ids of the driver locations at indexes and returns the
+// indexes of the driver locations that are not failed. Driver locations of
+// drivers get the ids of their drivers unless their id is the id of a
+// document of the same driver, they fail when it is the id of a document of
+// another driver.
+func (r *elasticRepository) resolveIDs(ctx context.Context, driverLocations []*models.DriverLocation, indexes []int, results []*models.UpsertResult) ([]int, error) {
+	ids := []string{}
+	for _, i := range indexes {
+		driverLocation := driverLocations[i]
+		if driverLocation.DriverID != "" && !driverLocation.ID.IsZero() && driverLocation.ID != elasticDriverID(driverLocation.DriverID) {
+			ids = append(ids, driverLocation.ID.Hex())
+		}
+	}
+
+	// drivers holds the driver ids of the documents with the given ids, the
+	// realtime get api sees documents that are not refreshed yet
+	drivers := map[string]string{}
+	if len(ids) > 0 {
+		var response struct {
+			Docs []struct {
+				ID     string          `json:"_id"`
+				Found  bool            `json:"found"`
+				Source elasticDocument `json:"_source"`
+			} `json:"docs"`
+		}
+		err := r.client.doJSON(ctx, http.MethodPost, "/"+Index+"/_mget", map[string]interface{}{"ids": ids}, &response)
+		if eErr, ok := err.(*elasticError); ok && eErr.Status == http.StatusNotFound {
+			// the index is created by the first bulk request
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, doc := range response.Docs {
+			if doc.Found {
+				drivers[doc.ID] = doc.Source.DriverID
+			}
+		}
+	}
+
+	valid := make([]int, 0, len(indexes))
+	for _, i := range indexes {
+		driverLocation := driverLocations[i]
+		switch driverID, found := drivers[driverLocation.ID.Hex()]; {
+		case driverLocation.DriverID == "":
+			if driverLocation.ID.IsZero() {
+				driverLocation.ID = primitive.NewObjectID()
+			}
+		case found && driverID != driverLocation.DriverID:
+			results[i] = models.NewUpsertResult(driverLocation, models.UpsertFailed, ErrIDConflict)
+			continue
+		case !found:
+			driverLocation.ID = elasticDriverID(driverLocation.DriverID)
+		}
+		valid = append(valid, i)
+	}
+
+	return valid, nil
+}
+
+// expire deletes driver locations updated before the given time with the
+// delete by query api.
+func (r *elasticRepository) expire(ctx context.Context, before time.Time) (int64, error) {
+	body := map[string]interface{}{
+		"query": map[string]interface{}{
+			"range": map[string]interface{}{
+				"updated_at": map[string]interface{}{"lt": before},
+			},
+		},
+	}
+
+	var response struct {
+		Deleted int64 `json:"deleted"`
+	}
+	if err := r.client.doJSON(ctx, http.MethodPost, "/"+Index+"/_delete_by_query", body, &response); err != nil {
+		return 0, err
+	}
+
+	return response.Deleted, nil
 }
 
-func (r *elasticRepository) Find(context.Context, *models.Query) ([]*models.DriverLocation, error) {
-	return nil, ErrNotImplemented
+// DropIfExists deletes the index
+func (r *elasticRepository) DropIfExists(ctx context.Context) error {
+	resp, err := r.client.do(ctx, http.MethodDelete, "/"+Index, "", nil)
+	if err != nil {
+		if eErr, ok := err.(*elasticError); ok && eErr.Status == http.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+
+	return resp.Body.Close()
 }
 
-func (r *elasticRepository) Find1(context.Context, *models.Query) ([]*models.DriverLocation, error) {
-	return nil, ErrNotImplemented
+// CreateIndex creates the index if it does not exist and maps the given
+// field with the given type, e.g. location => geo_point.
+func (r *elasticRepository) CreateIndex(ctx context.Context, key, value string) error {
+	mapping := map[string]interface{}{
+		"properties": map[string]interface{}{
+			key: map[string]interface{}{"type": value},
+		},
+	}
+
+	resp, err := r.client.do(ctx, http.MethodHead, "/"+Index, "", nil)
+	if err == nil {
+		resp.Body.Close()
+		return r.client.doJSON(ctx, http.MethodPut, "/"+Index+"/_mapping", mapping, nil)
+	}
+
+	if eErr, ok := err.(*elasticError); !ok || eErr.Status != http.StatusNotFound {
+		return err
+	}
+
+	return r.client.doJSON(ctx, http.MethodPut, "/"+Index, map[string]interface{}{
+		"mappings": mapping,
+	}, nil)
 }
 
-func (r *elasticRepository) DropIfExists(context.Context) error {
-	return ErrNotImplemented
+// Ping requests the root endpoint of elasticsearch
+func (r *elasticRepository) Ping(ctx context.Context) error {
+	resp, err := r.client.do(ctx, http.MethodGet, "/", "", nil)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
 }
 
-func (r *elasticRepository) CreateIndex(context.Context, string, string) error {
-	return ErrNotImplemented
+// CheckIndex checks location is mapped as geo_point
+func (r *elasticRepository) CheckIndex(ctx context.Context) error {
+	var mappings map[string]struct {
+		Mappings struct {
+			Properties map[string]struct {
+				Type string `json:"type"`
+			} `json:"properties"`
+		} `json:"mappings"`
+	}
+
+	resp, err := r.client.do(ctx, http.MethodGet, "/"+Index+"/_mapping", "", nil)
+	if err != nil {
+		if eErr, ok := err.(*elasticError); ok && eErr.Status == http.StatusNotFound {
+			return ErrIndexMissing
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(&mappings); err != nil {
+		return err
+	}
+
+	if len(mappings) == 0 {
+		return ErrIndexMissing
+	}
+
+	for _, index := range mappings {
+		if index.Mappings.Properties["location"].Type != "geo_point" {
+			return ErrIndexMissing
+		}
+	}
+	return nil
 }
 
-func (r *elasticRepository) Migrate(context.Context) {
-	log.Fatal(ErrNotImplemented)
+// Migrate recreates the index, the geo_point mapping must exist before
+// documents are indexed, otherwise location is mapped as an object.
+func (r *elasticRepository) Migrate(ctx context.Context) error {
+	if err := r.DropIfExists(ctx); err != nil {
+		return err
+	}
+
+	if err := r.CreateIndex(ctx, "location", "geo_point"); err != nil {
+		return err
+	}
+
+	for key, value := range elasticMappings {
+		if err := r.CreateIndex(ctx, key, value); err != nil {
+			return err
+		}
+	}
+
+	driverLocations, err := readSource(sourcePath)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(driverLocations); i += migrateBatchSize {
+		end := i + migrateBatchSize
+		if end > len(driverLocations) {
+			end = len(driverLocations)
+		}
+
+		if _, err := r.UpsertBulk(ctx, driverLocations[i:end]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// elasticClient is a minimal client of the elasticsearch rest api
+type elasticClient struct {
+	url    string
+	client *http.Client
+}
+
+// elasticError is returned when elasticsearch responds with a non 2xx status
+type elasticError struct {
+	Status int
+	Body   string
+}
+
+func (e *elasticError) Error() string {
+	return fmt.Sprintf("elastic error %d: %s", e.Status, e.Body)
+}
+
+// do sends a request to elasticsearch, the caller must close the response body.
+func (c *elasticClient) do(ctx context.Context, method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return nil, &elasticError{Status: resp.StatusCode, Body: string(b)}
+	}
+
+	return resp, nil
+}
+
+// doJSON sends body as json and decodes the response into out if it is not nil.
+func (c *elasticClient) doJSON(ctx context.Context, method, path string, body, out interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(ctx, method, path, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
 }
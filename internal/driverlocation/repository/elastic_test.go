@@ -0,0 +1,473 @@
+package repository
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/s3f4/locationmatcher/internal/driverlocation/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeElastic is an in-memory stand-in of the elasticsearch endpoints used by
+// elasticRepository, geo_distance queries are answered with haversine distances.
+type fakeElastic struct {
+	sync.Mutex
+	indices  map[string]map[string]elasticDocument
+	mappings map[string]map[string]fakeMapping
+}
+
+type fakeMapping struct {
+	Type string `json:"type"`
+}
+
+type fakeProperties struct {
+	Properties map[string]fakeMapping `json:"properties"`
+}
+
+// putMapping adds the mapped properties in body to the mappings of index
+func (f *fakeElastic) putMapping(index string, properties fakeProperties) {
+	if f.mappings[index] == nil {
+		f.mappings[index] = map[string]fakeMapping{}
+	}
+	for key, mapping := range properties.Properties {
+		f.mappings[index][key] = mapping
+	}
+}
+
+type fakeGeoDistance struct {
+	GeoDistance struct {
+		Distance string             `json:"distance"`
+		Location map[string]float64 `json:"location"`
+	} `json:"geo_distance"`
+}
+
+type fakeRange struct {
+	Range *struct {
+		UpdatedAt struct {
+			Gte *time.Time `json:"gte"`
+			Lt  *time.Time `json:"lt"`
+		} `json:"updated_at"`
+	} `json:"range"`
+}
+
+// match reports whether the document is in the range
+func (f fakeRange) match(doc elasticDocument) bool {
+	if f.Range == nil {
+		return true
+	}
+
+	if gte := f.Range.UpdatedAt.Gte; gte != nil && doc.UpdatedAt.Before(*gte) {
+		return false
+	}
+
+	if lt := f.Range.UpdatedAt.Lt; lt != nil && !doc.UpdatedAt.Before(*lt) {
+		return false
+	}
+
+	return true
+}
+
+func newFakeElastic(t *testing.T) *httptest.Server {
+	fake := &fakeElastic{
+		indices:  map[string]map[string]elasticDocument{},
+		mappings: map[string]map[string]fakeMapping{},
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (f *fakeElastic) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	index, endpoint := parts[0], ""
+	if len(parts) > 1 {
+		endpoint = parts[1]
+	}
+
+	docs, exists := f.indices[index]
+	switch {
+	case index == "":
+		json.NewEncoder(w).Encode(map[string]interface{}{"tagline": "You Know, for Search"})
+	case endpoint == "" && r.Method == http.MethodHead:
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case endpoint == "" && r.Method == http.MethodPut:
+		if exists {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.indices[index] = map[string]elasticDocument{}
+		var body struct {
+			Mappings fakeProperties `json:"mappings"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.putMapping(index, body.Mappings)
+	case endpoint == "" && r.Method == http.MethodDelete:
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.indices, index)
+		delete(f.mappings, index)
+	case endpoint == "_mapping" && r.Method == http.MethodGet:
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			index: map[string]interface{}{"mappings": fakeProperties{Properties: f.mappings[index]}},
+		})
+	case endpoint == "_mapping":
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var body fakeProperties
+		json.NewDecoder(r.Body).Decode(&body)
+		f.putMapping(index, body)
+	case endpoint == "_bulk":
+		f.bulk(w, r, index)
+	case endpoint == "_search":
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		f.search(w, r, docs)
+	case endpoint == "_mget":
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		f.mget(w, r, docs)
+	case endpoint == "_delete_by_query":
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		f.deleteByQuery(w, r, docs)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (f *fakeElastic) bulk(w http.ResponseWriter, r *http.Request, index string) {
+	if _, ok := f.indices[index]; !ok {
+		f.indices[index] = map[string]elasticDocument{}
+	}
+
+	response := elasticBulkResponse{}
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		var action map[string]map[string]string
+		if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		scanner.Scan()
+		var update elasticUpdate
+		if err := json.Unmarshal(scanner.Bytes(), &update); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		id := action["update"]["_id"]
+		item := elasticBulkItem{ID: id, Status: http.StatusCreated, Result: "created"}
+		doc := update.Upsert
+		if stored, ok := f.indices[index][id]; ok {
+			item.Status, item.Result = http.StatusOK, "updated"
+			doc = update.Doc
+			if doc.DriverID == "" {
+				doc.DriverID = stored.DriverID
+			}
+			if doc.Status == "" {
+				doc.Status = stored.Status
+			}
+		}
+
+		f.indices[index][id] = doc
+		response.Items = append(response.Items, map[string]elasticBulkItem{"update": item})
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func (f *fakeElastic) search(w http.ResponseWriter, r *http.Request, docs map[string]elasticDocument) {
+	var body struct {
+		From  int `json:"from"`
+		Size  int `json:"size"`
+		Query struct {
+			Bool struct {
+				Filter []struct {
+					fakeGeoDistance
+					fakeRange
+					Term struct {
+						Status models.DriverStatus `json:"status"`
+					} `json:"term"`
+				} `json:"filter"`
+				MustNot *fakeGeoDistance `json:"must_not"`
+			} `json:"bool"`
+		} `json:"query"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	meters := func(distance string) float64 {
+		d, _ := strconv.ParseFloat(strings.TrimSuffix(distance, "m"), 64)
+		return d
+	}
+
+	filter := body.Query.Bool.Filter[0].GeoDistance
+	maxDistance, minDistance := meters(filter.Distance), 0.0
+	if body.Query.Bool.MustNot != nil {
+		minDistance = meters(body.Query.Bool.MustNot.GeoDistance.Distance)
+	}
+
+	var status models.DriverStatus
+	ranges := []fakeRange{}
+	for _, f := range body.Query.Bool.Filter[1:] {
+		if f.Term.Status != "" {
+			status = f.Term.Status
+		}
+		ranges = append(ranges, f.fakeRange)
+	}
+
+	hits := []elasticHit{}
+	for id, doc := range docs {
+		if status != "" && doc.Status != status {
+			continue
+		}
+
+		inRange := true
+		for _, r := range ranges {
+			inRange = inRange && r.match(doc)
+		}
+		if !inRange {
+			continue
+		}
+
+		driverLocation := models.DriverLocation{Location: doc.Location}
+		d, err := driverLocation.CalculateDistance(filter.Location["lat"], filter.Location["lon"])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		d *= 1000
+		if d <= maxDistance && (body.Query.Bool.MustNot == nil || d > minDistance) {
+			hits = append(hits, elasticHit{ID: id, Source: doc, Sort: []float64{d}})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Sort[0] < hits[j].Sort[0] })
+
+	if body.From > len(hits) {
+		body.From = len(hits)
+	}
+	hits = hits[body.From:]
+	if body.Size < len(hits) {
+		hits = hits[:body.Size]
+	}
+
+	var response elasticSearchResponse
+	response.Hits.Hits = hits
+	json.NewEncoder(w).Encode(response)
+}
+
+func (f *fakeElastic) mget(w http.ResponseWriter, r *http.Request, docs map[string]elasticDocument) {
+	var body struct {
+		IDs []string `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	found := []map[string]interface{}{}
+	for _, id := range body.IDs {
+		doc, ok := docs[id]
+		found = append(found, map[string]interface{}{"_id": id, "found": ok, "_source": doc})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"docs": found})
+}
+
+func (f *fakeElastic) deleteByQuery(w http.ResponseWriter, r *http.Request, docs map[string]elasticDocument) {
+	var body struct {
+		Query fakeRange `json:"query"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	deleted := 0
+	for id, doc := range docs {
+		if body.Query.match(doc) {
+			delete(docs, id)
+			deleted++
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]int{"deleted": deleted})
+}
+
+func Test_Elastic(t *testing.T) {
+	ctx := context.Background()
+	Index = "driver_location"
+
+	server := newFakeElastic(t)
+	client, err := connectElastic(server.URL)
+	assert.Nil(t, err)
+
+	repo, err := NewRepository("elastic", client)
+	assert.Nil(t, err)
+
+	assert.Nil(t, repo.Ping(ctx))
+	assert.Equal(t, ErrIndexMissing, repo.CheckIndex(ctx))
+
+	err = repo.CreateIndex(ctx, "location", "geo_point")
+	assert.Nil(t, err)
+	assert.Nil(t, repo.CheckIndex(ctx))
+
+	// mapping is updated if the index exists
+	err = repo.CreateIndex(ctx, "location", "geo_point")
+	assert.Nil(t, err)
+
+	driverLocations := []*models.DriverLocation{
+		// galata
+		{
+			Location: models.Location{
+				Type:        "Point",
+				Coordinates: []interface{}{28.97413088610361, 41.025651081666744},
+			},
+		},
+		// ayasofya
+		{
+			Location: models.Location{
+				Type:        "Point",
+				Coordinates: []interface{}{28.979986854317975, 41.00858654897259},
+			},
+		},
+	}
+
+	results, err := repo.UpsertBulk(ctx, driverLocations)
+	assert.Nil(t, err)
+	assert.Equal(t, models.UpsertInserted, results[0].Status)
+	assert.False(t, driverLocations[0].ID.IsZero())
+
+	// it should return ayasofya -> galata
+	query := &models.Query{
+		Location: models.Location{
+			Type:        "Point",
+			Coordinates: []interface{}{28.9605116156308, 41.01189519061322},
+		},
+		MinDistance: 0,
+		MaxDistance: 10000,
+	}
+
+	locations, err := repo.Find1(ctx, query)
+	assert.Nil(t, err)
+	assert.Len(t, locations, 2)
+	assert.Equal(t, driverLocations[1].ID, locations[0].ID)
+	assert.Equal(t, driverLocations[0].ID, locations[1].ID)
+	assert.NotNil(t, locations[0].MongoDistance)
+	assert.InDelta(t, locations[0].Distance, *locations[0].MongoDistance, 0.001)
+
+	// limit and offset page the sorted results
+	query.Limit, query.Offset = 1, 1
+	locations, err = repo.Find1(ctx, query)
+	assert.Nil(t, err)
+	assert.Len(t, locations, 1)
+	assert.Equal(t, driverLocations[0].ID, locations[0].ID)
+	query.Limit, query.Offset = 0, 0
+
+	// only drivers with the queried status are returned
+	driverLocations[1].Status = models.StatusBusy
+	_, err = repo.UpsertBulk(ctx, driverLocations[1:2])
+	assert.Nil(t, err)
+
+	query.Status = models.StatusAvailable
+	locations, err = repo.Find1(ctx, query)
+	assert.Nil(t, err)
+	assert.Len(t, locations, 1)
+	assert.Equal(t, driverLocations[0].ID, locations[0].ID)
+	assert.Equal(t, models.StatusAvailable, locations[0].Status)
+	assert.False(t, locations[0].UpdatedAt.IsZero())
+	query.Status = ""
+
+	// ayasofya is closer than minDistance
+	query.MinDistance = 1700
+	locations, err = repo.Find(ctx, query)
+	assert.Nil(t, err)
+	assert.Len(t, locations, 1)
+	assert.Equal(t, driverLocations[0].ID, locations[0].ID)
+	assert.Nil(t, locations[0].MongoDistance)
+
+	// upsert with an id replaces the document
+	driverLocations[0].Location.Coordinates = []interface{}{29.5, 41.5}
+	results, err = repo.UpsertBulk(ctx, driverLocations[:1])
+	assert.Nil(t, err)
+	assert.Equal(t, models.UpsertUpdated, results[0].Status)
+
+	locations, err = repo.Find(ctx, query)
+	assert.Nil(t, err)
+	assert.Len(t, locations, 0)
+
+	// stale driver locations are not returned and removed by expire
+	MaxAge = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+	query.MinDistance = 0
+	locations, err = repo.Find1(ctx, query)
+	assert.Nil(t, err)
+	assert.Len(t, locations, 0)
+	MaxAge = 0
+
+	removed, err := repo.(expirer).expire(ctx, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), removed)
+
+	locations, err = repo.Find1(ctx, query)
+	assert.Nil(t, err)
+	assert.Len(t, locations, 0)
+
+	err = repo.DropIfExists(ctx)
+	assert.Nil(t, err)
+
+	// dropping a missing index is not an error
+	err = repo.DropIfExists(ctx)
+	assert.Nil(t, err)
+
+	_, err = repo.Find1(ctx, query)
+	assert.IsType(t, &elasticError{}, err)
+
+	testUpsertByDriverID(ctx, t, repo)
+	testIDConflict(ctx, t, repo)
+
+	// documents of drivers are keyed by their driver ids
+	driverLocation := &models.DriverLocation{
+		DriverID: "driver-1",
+		Location: models.Location{Type: "Point", Coordinates: []interface{}{28.97, 41.02}},
+	}
+	_, err = repo.UpsertBulk(ctx, []*models.DriverLocation{driverLocation})
+	assert.Nil(t, err)
+	assert.Equal(t, elasticDriverID("driver-1"), driverLocation.ID)
+}
+
+func Test_Elastic_ClientType(t *testing.T) {
+	_, err := NewRepository("elastic", nil)
+	assert.Equal(t, ErrClientType, err)
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/s3f4/locationmatcher/pkg/log"
+)
+
+var (
+	// MaxAge is the age after which driver locations are stale, stale driver
+	// locations are not returned by Find and Find1. 0 disables expiry.
+	MaxAge = parseMaxAge(os.Getenv("DRIVER_LOCATION_MAX_AGE"))
+)
+
+// sweepInterval is how often expired driver locations are removed by backends
+// without native expiry
+const sweepInterval = time.Minute
+
+// expirer is implemented by backends that can not expire driver locations by
+// themselves, mongodb uses a TTL index instead.
+type expirer interface {
+	// expire removes driver locations updated before the given time and
+	// returns the number of removed driver locations.
+	expire(ctx context.Context, before time.Time) (int64, error)
+}
+
+// parseMaxAge parses a duration like 5m, invalid values disable expiry
+func parseMaxAge(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	maxAge, err := time.ParseDuration(value)
+	if err != nil || maxAge < 0 {
+		log.Warnf("invalid DRIVER_LOCATION_MAX_AGE %q, expiry is disabled", value)
+		return 0
+	}
+
+	return maxAge
+}
+
+// expiredBefore returns the update time before which driver locations are
+// stale, it is zero when expiry is disabled.
+func expiredBefore(now time.Time) time.Time {
+	if MaxAge <= 0 {
+		return time.Time{}
+	}
+
+	return now.Add(-MaxAge).UTC()
+}
+
+// Sweep removes stale driver locations every sweepInterval until ctx is done,
+// it returns immediately when expiry is disabled or the backend expires
+// driver locations by itself.
+func Sweep(ctx context.Context, repository Repository) {
+	e, ok := repository.(expirer)
+	if !ok || MaxAge <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			removed, err := e.expire(ctx, expiredBefore(now))
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+
+			if removed > 0 {
+				log.Infof("%d stale driver locations are removed", removed)
+			}
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseMaxAge(t *testing.T) {
+	assert.Equal(t, time.Duration(0), parseMaxAge(""))
+	assert.Equal(t, 5*time.Minute, parseMaxAge("5m"))
+	assert.Equal(t, time.Duration(0), parseMaxAge("five minutes"))
+	assert.Equal(t, time.Duration(0), parseMaxAge("-5m"))
+}
+
+func Test_expiredBefore(t *testing.T) {
+	now := time.Now()
+	assert.True(t, expiredBefore(now).IsZero())
+
+	MaxAge = 5 * time.Minute
+	defer func() { MaxAge = 0 }()
+	assert.True(t, now.Add(-5*time.Minute).Equal(expiredBefore(now)))
+}
+
+func Test_Sweep_Disabled(t *testing.T) {
+	done := make(chan struct{})
+	go func() {
+		Sweep(context.Background(), newMemoryRepository())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Sweep must return when expiry is disabled")
+	}
+}
@@ -3,6 +3,8 @@ package repository
 import (
 	"fmt"
 
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
@@ -23,7 +25,28 @@ func NewRepository(repository string, client interface{}) (Repository, error) {
 		return &mongoRepository{client: mongoClient}, nil
 
 	case elasticKey:
-		return &elasticRepository{}, nil
+		elasticClient, ok := client.(*elasticClient)
+		if !ok {
+			return nil, ErrClientType
+		}
+		return &elasticRepository{client: elasticClient}, nil
+
+	case redisKey:
+		redisClient, ok := client.(*redis.Client)
+		if !ok {
+			return nil, ErrClientType
+		}
+		return &redisRepository{client: redisClient}, nil
+
+	case postgisKey:
+		pool, ok := client.(*pgxpool.Pool)
+		if !ok {
+			return nil, ErrClientType
+		}
+		return &postgisRepository{pool: pool}, nil
+
+	case memoryKey:
+		return newMemoryRepository(), nil
 
 	default:
 		return nil, fmt.Errorf("no such repository %s", repository)
@@ -0,0 +1,113 @@
+package repository
+
+import "math"
+
+const (
+	geohashBase32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+	// geohashMaxPrecision is the finest precision kept in the index,
+	// a cell is about 1.2km x 0.6km.
+	geohashMaxPrecision = 6
+	// geohashMaxCells is the upper limit of cells visited by a query,
+	// coarser precisions are used for larger distances.
+	geohashMaxCells = 64
+	// earthRadius is the mean radius of Earth in meters
+	earthRadius = 6371000
+)
+
+// geohashEncode returns the geohash of the given point with the given precision.
+func geohashEncode(latitude, longitude float64, precision int) string {
+	latRange := [2]float64{-90, 90}
+	lngRange := [2]float64{-180, 180}
+
+	hash := make([]byte, 0, precision)
+	bit, ch, even := 0, 0, true
+	for len(hash) < precision {
+		if even {
+			mid := (lngRange[0] + lngRange[1]) / 2
+			if longitude >= mid {
+				ch |= 1 << (4 - bit)
+				lngRange[0] = mid
+			} else {
+				lngRange[1] = mid
+			}
+		} else {
+			mid := (latRange[0] + latRange[1]) / 2
+			if latitude >= mid {
+				ch |= 1 << (4 - bit)
+				latRange[0] = mid
+			} else {
+				latRange[1] = mid
+			}
+		}
+
+		even = !even
+		if bit < 4 {
+			bit++
+		} else {
+			hash = append(hash, geohashBase32[ch])
+			bit, ch = 0, 0
+		}
+	}
+
+	return string(hash)
+}
+
+// geohashCellSize returns the latitude and longitude span of a cell in degrees.
+func geohashCellSize(precision int) (float64, float64) {
+	bits := precision * 5
+	lngBits := (bits + 1) / 2
+	latBits := bits / 2
+	return 180 / math.Pow(2, float64(latBits)), 360 / math.Pow(2, float64(lngBits))
+}
+
+// geohashCover returns the cells that cover the circle with the given center
+// and radius in meters. The finest precision that needs at most
+// geohashMaxCells cells is used.
+func geohashCover(latitude, longitude, radius float64) []string {
+	dLat := radius / earthRadius * 180 / math.Pi
+	minLat := math.Max(latitude-dLat, -90)
+	maxLat := math.Min(latitude+dLat, 90)
+
+	// longitude span grows towards the poles, the whole range is used if
+	// the circle contains a pole.
+	minLng, maxLng := -180.0, 180.0
+	if maxLat < 90 && minLat > -90 {
+		dLng := math.Asin(math.Min(math.Sin(radius/earthRadius)/math.Cos(latitude*math.Pi/180), 1)) * 180 / math.Pi
+		minLng, maxLng = longitude-dLng, longitude+dLng
+	}
+
+	for precision := geohashMaxPrecision; precision > 1; precision-- {
+		latSize, lngSize := geohashCellSize(precision)
+		latCells := math.Floor(maxLat/latSize) - math.Floor(minLat/latSize) + 1
+		lngCells := math.Floor(maxLng/lngSize) - math.Floor(minLng/lngSize) + 1
+		if latCells*lngCells <= geohashMaxCells {
+			return geohashCells(minLat, maxLat, minLng, maxLng, precision)
+		}
+	}
+
+	return geohashCells(minLat, maxLat, minLng, maxLng, 1)
+}
+
+// geohashCells returns cells of the bounding box, longitudes outside of
+// [-180, 180] are wrapped around the antimeridian.
+func geohashCells(minLat, maxLat, minLng, maxLng float64, precision int) []string {
+	latSize, lngSize := geohashCellSize(precision)
+	seen := map[string]bool{}
+	cells := []string{}
+
+	for lat := math.Floor(minLat/latSize) * latSize; lat <= maxLat; lat += latSize {
+		for lng := math.Floor(minLng/lngSize) * lngSize; lng <= maxLng; lng += lngSize {
+			// center of the cell avoids floating point errors on the edges
+			cLat := math.Min(lat+latSize/2, 90)
+			cLng := math.Mod(lng+lngSize/2+540, 360) - 180
+
+			cell := geohashEncode(cLat, cLng, precision)
+			if !seen[cell] {
+				seen[cell] = true
+				cells = append(cells, cell)
+			}
+		}
+	}
+
+	return cells
+}
@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/s3f4/locationmatcher/internal/driverlocation/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of the spans of repositories
+const tracerName = "github.com/s3f4/locationmatcher/internal/driverlocation/repository"
+
+// dbSystems are the db.system attributes of the spans of backends
+var dbSystems = map[string]attribute.KeyValue{
+	mongoKey:   semconv.DBSystemMongoDB,
+	elasticKey: semconv.DBSystemElasticsearch,
+	redisKey:   semconv.DBSystemRedis,
+	postgisKey: semconv.DBSystemPostgreSQL,
+}
+
+var (
+	operationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "driver_location_repository_operation_duration_seconds",
+		Help:    "Latency of repository operations by backend, method and result.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"backend", "method", "result"})
+
+	upsertBatchSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "driver_location_upsert_batch_size",
+		Help:    "Number of driver locations in bulk upserts by backend.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 11),
+	}, []string{"backend"})
+)
+
+// instrumented traces and observes the operations of a repository
+type instrumented struct {
+	repository Repository
+	backend    string
+}
+
+// instrumentedExpirer is an instrumented repository of a backend that
+// implements expirer, so that Sweep keeps working.
+type instrumentedExpirer struct {
+	*instrumented
+	expirer expirer
+}
+
+// Instrument returns a repository that traces the operations of repository
+// and observes their latency and the size of bulk upserts, labeled by backend.
+func Instrument(backend string, repository Repository) Repository {
+	r := &instrumented{repository: repository, backend: backend}
+	if e, ok := repository.(expirer); ok {
+		return &instrumentedExpirer{instrumented: r, expirer: e}
+	}
+	return r
+}
+
+// start starts the span of method, the returned function ends it and
+// observes the latency of method.
+func (r *instrumented) start(ctx context.Context, method string) (context.Context, func(error)) {
+	system, ok := dbSystems[r.backend]
+	if !ok {
+		system = semconv.DBSystemKey.String(r.backend)
+	}
+
+	start := time.Now()
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "repository."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(system, semconv.DBOperationKey.String(method)),
+	)
+
+	return ctx, func(err error) {
+		result := "ok"
+		if err != nil {
+			result = "error"
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		operationDuration.WithLabelValues(r.backend, method, result).Observe(time.Since(start).Seconds())
+	}
+}
+
+func (r *instrumented) UpsertBulk(ctx context.Context, driverLocations []*models.DriverLocation) (results []*models.UpsertResult, err error) {
+	upsertBatchSize.WithLabelValues(r.backend).Observe(float64(len(driverLocations)))
+	ctx, end := r.start(ctx, "UpsertBulk")
+	defer func() { end(err) }()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("batch_size", len(driverLocations)))
+	return r.repository.UpsertBulk(ctx, driverLocations)
+}
+
+func (r *instrumented) Find(ctx context.Context, query *models.Query) (driverLocations []*models.DriverLocation, err error) {
+	ctx, end := r.start(ctx, "Find")
+	defer func() { end(err) }()
+	return r.repository.Find(ctx, query)
+}
+
+func (r *instrumented) Find1(ctx context.Context, query *models.Query) (driverLocations []*models.DriverLocation, err error) {
+	ctx, end := r.start(ctx, "Find1")
+	defer func() { end(err) }()
+	return r.repository.Find1(ctx, query)
+}
+
+func (r *instrumented) DropIfExists(ctx context.Context) (err error) {
+	ctx, end := r.start(ctx, "DropIfExists")
+	defer func() { end(err) }()
+	return r.repository.DropIfExists(ctx)
+}
+
+func (r *instrumented) CreateIndex(ctx context.Context, key, indexType string) (err error) {
+	ctx, end := r.start(ctx, "CreateIndex")
+	defer func() { end(err) }()
+	return r.repository.CreateIndex(ctx, key, indexType)
+}
+
+func (r *instrumented) Migrate(ctx context.Context) (err error) {
+	ctx, end := r.start(ctx, "Migrate")
+	defer func() { end(err) }()
+	return r.repository.Migrate(ctx)
+}
+
+func (r *instrumented) Ping(ctx context.Context) (err error) {
+	ctx, end := r.start(ctx, "Ping")
+	defer func() { end(err) }()
+	return r.repository.Ping(ctx)
+}
+
+func (r *instrumented) CheckIndex(ctx context.Context) (err error) {
+	ctx, end := r.start(ctx, "CheckIndex")
+	defer func() { end(err) }()
+	return r.repository.CheckIndex(ctx)
+}
+
+func (r *instrumentedExpirer) expire(ctx context.Context, before time.Time) (removed int64, err error) {
+	ctx, end := r.start(ctx, "expire")
+	defer func() { end(err) }()
+	return r.expirer.expire(ctx, before)
+}
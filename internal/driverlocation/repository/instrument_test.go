@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/s3f4/locationmatcher/internal/driverlocation/models"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+)
+
+// failingRepository fails every Find
+type failingRepository struct {
+	Repository
+}
+
+func (r *failingRepository) Find(context.Context, *models.Query) ([]*models.DriverLocation, error) {
+	return nil, ErrNotImplemented
+}
+
+// histogram returns the observations of a histogram
+func histogram(t *testing.T, observer prometheus.Observer) *dto.Histogram {
+	metric := &dto.Metric{}
+	assert.Nil(t, observer.(prometheus.Metric).Write(metric))
+	return metric.GetHistogram()
+}
+
+func Test_Instrument(t *testing.T) {
+	ctx := context.Background()
+	repo := Instrument("test", newMemoryRepository())
+
+	// memory repository expires driver locations by Sweep
+	_, ok := repo.(expirer)
+	assert.True(t, ok)
+
+	driverLocations := []*models.DriverLocation{
+		{Location: models.Location{Type: "Point", Coordinates: []interface{}{28.97413088610361, 41.025651081666744}}},
+		{Location: models.Location{Type: "Point", Coordinates: []interface{}{28.979986854317975, 41.00858654897259}}},
+	}
+	_, err := repo.UpsertBulk(ctx, driverLocations)
+	assert.Nil(t, err)
+
+	_, err = repo.Find(ctx, &models.Query{
+		Location:    models.Location{Type: "Point", Coordinates: []interface{}{28.97413088610361, 41.025651081666744}},
+		MaxDistance: 10000,
+	})
+	assert.Nil(t, err)
+
+	_, err = repo.(expirer).expire(ctx, time.Now())
+	assert.Nil(t, err)
+
+	_, err = Instrument("test", &failingRepository{}).Find(ctx, &models.Query{})
+	assert.NotNil(t, err)
+
+	assert.Equal(t, uint64(1), histogram(t, operationDuration.WithLabelValues("test", "UpsertBulk", "ok")).GetSampleCount())
+	assert.Equal(t, uint64(1), histogram(t, operationDuration.WithLabelValues("test", "Find", "ok")).GetSampleCount())
+	assert.Equal(t, uint64(1), histogram(t, operationDuration.WithLabelValues("test", "Find", "error")).GetSampleCount())
+	assert.Equal(t, uint64(1), histogram(t, operationDuration.WithLabelValues("test", "expire", "ok")).GetSampleCount())
+
+	batchSize := histogram(t, upsertBatchSize.WithLabelValues("test"))
+	assert.Equal(t, uint64(1), batchSize.GetSampleCount())
+	assert.Equal(t, 2.0, batchSize.GetSampleSum())
+}
+
+func Test_Instrument_NoExpirer(t *testing.T) {
+	repo := Instrument("test", &mongoRepository{})
+	_, ok := repo.(expirer)
+	assert.False(t, ok)
+}
+
+func Test_Instrument_Spans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+	_, err := Instrument(postgisKey, &failingRepository{}).Find(ctx, &models.Query{})
+	assert.NotNil(t, err)
+	parent.End()
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "repository.Find", spans[0].Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Contains(t, spans[0].Attributes(), semconv.DBSystemPostgreSQL)
+	assert.Contains(t, spans[0].Attributes(), semconv.DBOperationKey.String("Find"))
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+}
@@ -0,0 +1,248 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/s3f4/locationmatcher/internal/driverlocation/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryRepository keeps driver locations in process, locations are indexed
+// by their geohash on every precision up to geohashMaxPrecision so that a
+// query can visit a few cells of a suitable size.
+type memoryRepository struct {
+	sync.RWMutex
+	locations map[primitive.ObjectID]*memoryLocation
+	// cells holds driver location ids by geohash, cells of all
+	// precisions are kept in the same map
+	cells map[string]map[primitive.ObjectID]struct{}
+	// drivers holds driver location ids by driver id
+	drivers map[string]primitive.ObjectID
+}
+
+type memoryLocation struct {
+	driverLocation models.DriverLocation
+	geohash        string
+}
+
+func newMemoryRepository() *memoryRepository {
+	return &memoryRepository{
+		locations: map[primitive.ObjectID]*memoryLocation{},
+		cells:     map[string]map[primitive.ObjectID]struct{}{},
+		drivers:   map[string]primitive.ObjectID{},
+	}
+}
+
+// Find returns driver locations between min and max distance sorted by
+// distance like $nearSphere.
+func (r *memoryRepository) Find(ctx context.Context, query *models.Query) ([]*models.DriverLocation, error) {
+	return r.near(query, query.MinDistance, false)
+}
+
+// Find1 returns driver locations within max distance sorted by distance
+// like $geoNear, MongoDistance holds the distance in kilometers.
+func (r *memoryRepository) Find1(ctx context.Context, query *models.Query) ([]*models.DriverLocation, error) {
+	return r.near(query, 0, true)
+}
+
+func (r *memoryRepository) near(query *models.Query, minDistance int64, setDistance bool) ([]*models.DriverLocation, error) {
+	coords, _ := query.Location.Coordinates.([]interface{})
+	longitude := coords[0].(float64)
+	latitude := coords[1].(float64)
+
+	before := expiredBefore(time.Now())
+
+	r.RLock()
+	defer r.RUnlock()
+
+	driverLocations := []*models.DriverLocation{}
+	for _, cell := range geohashCover(latitude, longitude, float64(query.MaxDistance)) {
+		for id := range r.cells[cell] {
+			location := r.locations[id]
+			if query.Status != "" && location.driverLocation.Status != query.Status {
+				continue
+			}
+
+			if location.driverLocation.UpdatedAt.Before(before) {
+				continue
+			}
+
+			distance, err := location.driverLocation.CalculateDistance(latitude, longitude)
+			if err != nil {
+				return nil, err
+			}
+
+			meters := distance * 1000
+			if meters < float64(minDistance) || meters > float64(query.MaxDistance) {
+				continue
+			}
+
+			// stored coordinates are not shared with the caller
+			coordinates := location.driverLocation.Location.Coordinates.([]float64)
+			driverLocation := location.driverLocation
+			driverLocation.Location.Coordinates = []float64{coordinates[0], coordinates[1]}
+			driverLocation.Distance = distance
+			if setDistance {
+				mDistance := distance
+				driverLocation.MongoDistance = &mDistance
+			}
+			driverLocations = append(driverLocations, &driverLocation)
+		}
+	}
+
+	sort.SliceStable(driverLocations, func(i, j int) bool {
+		return driverLocations[i].Distance < driverLocations[j].Distance
+	})
+
+	return paginate(driverLocations, query), nil
+}
+
+// UpsertBulk creates or updates driver locations by driver id, or by id if
+// the driver id is empty. Driver locations with invalid coordinates or with
+// the id of another driver fail without affecting the others.
+func (r *memoryRepository) UpsertBulk(ctx context.Context, driverLocations []*models.DriverLocation) ([]*models.UpsertResult, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now().UTC()
+	results := make([]*models.UpsertResult, 0, len(driverLocations))
+	for _, driverLocation := range driverLocations {
+		coordinates, err := driverLocation.Coordinates()
+		if err != nil {
+			results = append(results, models.NewUpsertResult(driverLocation, models.UpsertFailed, err))
+			continue
+		}
+
+		if id, ok := r.drivers[driverLocation.DriverID]; ok && driverLocation.DriverID != "" {
+			driverLocation.ID = id
+		} else if driverLocation.ID.IsZero() {
+			driverLocation.ID = primitive.NewObjectID()
+		}
+
+		status := models.UpsertInserted
+		var stored models.DriverStatus
+		if old, ok := r.locations[driverLocation.ID]; ok {
+			// updates by id keep the driver of the driver location
+			if driverLocation.DriverID == "" {
+				driverLocation.DriverID = old.driverLocation.DriverID
+			} else if driverLocation.DriverID != old.driverLocation.DriverID {
+				results = append(results, models.NewUpsertResult(driverLocation, models.UpsertFailed, ErrIDConflict))
+				continue
+			}
+
+			stored = old.driverLocation.Status
+			r.unindex(driverLocation.ID, old.geohash)
+			status = models.UpsertUpdated
+		}
+		driverLocation.Touch(now)
+		driverLocation.KeepStatus(stored)
+
+		location := &memoryLocation{
+			driverLocation: models.DriverLocation{
+				ID:        driverLocation.ID,
+				DriverID:  driverLocation.DriverID,
+				Status:    driverLocation.Status,
+				UpdatedAt: driverLocation.UpdatedAt,
+				Location: models.Location{
+					Type:        driverLocation.Location.Type,
+					Coordinates: []float64{coordinates[0], coordinates[1]},
+				},
+			},
+			geohash: geohashEncode(coordinates[1], coordinates[0], geohashMaxPrecision),
+		}
+
+		r.locations[driverLocation.ID] = location
+		r.index(driverLocation.ID, location.geohash)
+		if driverLocation.DriverID != "" {
+			r.drivers[driverLocation.DriverID] = driverLocation.ID
+		}
+
+		results = append(results, models.NewUpsertResult(driverLocation, status, nil))
+	}
+
+	return results, nil
+}
+
+func (r *memoryRepository) index(id primitive.ObjectID, geohash string) {
+	for precision := 1; precision <= len(geohash); precision++ {
+		cell := geohash[:precision]
+		if r.cells[cell] == nil {
+			r.cells[cell] = map[primitive.ObjectID]struct{}{}
+		}
+		r.cells[cell][id] = struct{}{}
+	}
+}
+
+func (r *memoryRepository) unindex(id primitive.ObjectID, geohash string) {
+	for precision := 1; precision <= len(geohash); precision++ {
+		cell := geohash[:precision]
+		delete(r.cells[cell], id)
+		if len(r.cells[cell]) == 0 {
+			delete(r.cells, cell)
+		}
+	}
+}
+
+// expire removes driver locations updated before the given time
+func (r *memoryRepository) expire(ctx context.Context, before time.Time) (int64, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	var removed int64
+	for id, location := range r.locations {
+		if location.driverLocation.UpdatedAt.Before(before) {
+			r.unindex(id, location.geohash)
+			delete(r.locations, id)
+			delete(r.drivers, location.driverLocation.DriverID)
+			removed++
+		}
+	}
+
+	return removed, nil
+}
+
+// DropIfExists removes all driver locations
+func (r *memoryRepository) DropIfExists(ctx context.Context) error {
+	r.Lock()
+	defer r.Unlock()
+
+	r.locations = map[primitive.ObjectID]*memoryLocation{}
+	r.cells = map[string]map[primitive.ObjectID]struct{}{}
+	r.drivers = map[string]primitive.ObjectID{}
+	return nil
+}
+
+// CreateIndex does nothing, the geohash index is always maintained
+func (r *memoryRepository) CreateIndex(context.Context, string, string) error {
+	return nil
+}
+
+// Ping does nothing, driver locations are in memory
+func (r *memoryRepository) Ping(context.Context) error {
+	return nil
+}
+
+// CheckIndex does nothing, the geohash index is always maintained
+func (r *memoryRepository) CheckIndex(context.Context) error {
+	return nil
+}
+
+func (r *memoryRepository) Migrate(ctx context.Context) error {
+	if err := r.DropIfExists(ctx); err != nil {
+		return err
+	}
+
+	driverLocations, err := readSource(sourcePath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.UpsertBulk(ctx, driverLocations); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,219 @@
+package repository
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/s3f4/locationmatcher/internal/driverlocation/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_geohashEncode(t *testing.T) {
+	// galata
+	assert.Equal(t, "sxk97k", geohashEncode(41.025651081666744, 28.97413088610361, 6))
+	assert.Equal(t, "ezs42", geohashEncode(42.6, -5.6, 5))
+	assert.Equal(t, "s", geohashEncode(0, 0, 1))
+}
+
+func Test_geohashCover(t *testing.T) {
+	cells := geohashCover(41.01189519061322, 28.9605116156308, 1000)
+	assert.LessOrEqual(t, len(cells), geohashMaxCells)
+	assert.Len(t, cells[0], geohashMaxPrecision)
+
+	// larger distances use coarser cells
+	cells = geohashCover(41.01189519061322, 28.9605116156308, 1000000)
+	assert.LessOrEqual(t, len(cells), geohashMaxCells)
+	assert.Less(t, len(cells[0]), geohashMaxPrecision)
+
+	// the circle contains the north pole
+	cells = geohashCover(89.9, 0, 100000)
+	assert.LessOrEqual(t, len(cells), geohashMaxCells)
+}
+
+func Test_Memory(t *testing.T) {
+	ctx := context.Background()
+	repo, err := NewRepository("memory", nil)
+	assert.Nil(t, err)
+
+	driverLocations := []*models.DriverLocation{
+		// galata
+		{
+			Location: models.Location{
+				Type:        "Point",
+				Coordinates: []interface{}{28.97413088610361, 41.025651081666744},
+			},
+		},
+		// ayasofya
+		{
+			Location: models.Location{
+				Type:        "Point",
+				Coordinates: []interface{}{28.979986854317975, 41.00858654897259},
+			},
+		},
+		// eiffel
+		{
+			Location: models.Location{
+				Type:        "Point",
+				Coordinates: []interface{}{2.2945, 48.8583},
+			},
+		},
+	}
+
+	results, err := repo.UpsertBulk(ctx, driverLocations)
+	assert.Nil(t, err)
+	assert.Equal(t, models.UpsertInserted, results[0].Status)
+	assert.False(t, driverLocations[0].ID.IsZero())
+
+	// it should return ayasofya -> galata
+	query := &models.Query{
+		Location: models.Location{
+			Type:        "Point",
+			Coordinates: []interface{}{28.9605116156308, 41.01189519061322},
+		},
+		MinDistance: 0,
+		MaxDistance: 10000,
+	}
+
+	locations, err := repo.Find1(ctx, query)
+	assert.Nil(t, err)
+	assert.Len(t, locations, 2)
+	assert.Equal(t, driverLocations[1].ID, locations[0].ID)
+	assert.Equal(t, driverLocations[0].ID, locations[1].ID)
+	assert.Equal(t, locations[0].Distance, *locations[0].MongoDistance)
+
+	// limit and offset page the sorted results
+	query.Limit, query.Offset = 1, 1
+	locations, err = repo.Find1(ctx, query)
+	assert.Nil(t, err)
+	assert.Len(t, locations, 1)
+	assert.Equal(t, driverLocations[0].ID, locations[0].ID)
+	query.Limit, query.Offset = 0, 0
+
+	// only drivers with the queried status are returned
+	driverLocations[1].Status = models.StatusBusy
+	_, err = repo.UpsertBulk(ctx, driverLocations[1:2])
+	assert.Nil(t, err)
+
+	query.Status = models.StatusAvailable
+	locations, err = repo.Find1(ctx, query)
+	assert.Nil(t, err)
+	assert.Len(t, locations, 1)
+	assert.Equal(t, driverLocations[0].ID, locations[0].ID)
+	assert.Equal(t, models.StatusAvailable, locations[0].Status)
+	assert.False(t, locations[0].UpdatedAt.IsZero())
+	query.Status = ""
+
+	// ayasofya is closer than minDistance
+	query.MinDistance = 1700
+	locations, err = repo.Find(ctx, query)
+	assert.Nil(t, err)
+	assert.Len(t, locations, 1)
+	assert.Equal(t, driverLocations[0].ID, locations[0].ID)
+	assert.Nil(t, locations[0].MongoDistance)
+
+	// results must not share state with the repository
+	locations[0].Location.Coordinates.([]float64)[0] = 0
+	locations, err = repo.Find(ctx, query)
+	assert.Nil(t, err)
+	assert.Equal(t, 28.97413088610361, locations[0].Location.Coordinates.([]float64)[0])
+
+	// upsert with an id moves the driver location
+	driverLocations[0].Location.Coordinates = []interface{}{29.5, 41.5}
+	results, err = repo.UpsertBulk(ctx, driverLocations[:1])
+	assert.Nil(t, err)
+	assert.Equal(t, models.UpsertUpdated, results[0].Status)
+
+	locations, err = repo.Find(ctx, query)
+	assert.Nil(t, err)
+	assert.Len(t, locations, 0)
+
+	// invalid coordinates fail without a write
+	results, err = repo.UpsertBulk(ctx, []*models.DriverLocation{
+		{Location: models.Location{Type: "Point", Coordinates: []int{1, 2}}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, models.UpsertFailed, results[0].Status)
+
+	query.MinDistance = 0
+	query.MaxDistance = 3000000
+	locations, err = repo.Find1(ctx, query)
+	assert.Nil(t, err)
+	assert.Len(t, locations, 3)
+	assert.Equal(t, driverLocations[2].ID, locations[2].ID)
+
+	// stale driver locations are not returned and removed by expire
+	MaxAge = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+	query.MinDistance = 0
+	locations, err = repo.Find1(ctx, query)
+	assert.Nil(t, err)
+	assert.Len(t, locations, 0)
+	MaxAge = 0
+
+	removed, err := repo.(expirer).expire(ctx, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), removed)
+
+	locations, err = repo.Find1(ctx, query)
+	assert.Nil(t, err)
+	assert.Len(t, locations, 0)
+
+	err = repo.DropIfExists(ctx)
+	assert.Nil(t, err)
+
+	locations, err = repo.Find1(ctx, query)
+	assert.Nil(t, err)
+	assert.Len(t, locations, 0)
+
+	testUpsertByDriverID(ctx, t, repo)
+	testIDConflict(ctx, t, repo)
+}
+
+// Test_Memory_BruteForce compares the index with a scan of all locations
+func Test_Memory_BruteForce(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepository()
+	random := rand.New(rand.NewSource(1))
+
+	driverLocations := []*models.DriverLocation{}
+	for i := 0; i < 2000; i++ {
+		driverLocations = append(driverLocations, &models.DriverLocation{
+			Location: models.Location{
+				Type:        "Point",
+				Coordinates: []float64{28.5 + random.Float64(), 40.5 + random.Float64()},
+			},
+		})
+	}
+	_, err := repo.UpsertBulk(ctx, driverLocations)
+	assert.Nil(t, err)
+
+	for _, maxDistance := range []int64{500, 5000, 20000, 200000} {
+		query := &models.Query{
+			Location: models.Location{
+				Type:        "Point",
+				Coordinates: []interface{}{29.0, 41.0},
+			},
+			MinDistance: maxDistance / 10,
+			MaxDistance: maxDistance,
+		}
+
+		expected := []float64{}
+		for _, driverLocation := range driverLocations {
+			d, _ := driverLocation.CalculateDistance(41.0, 29.0)
+			if d*1000 >= float64(query.MinDistance) && d*1000 <= float64(query.MaxDistance) {
+				expected = append(expected, d)
+			}
+		}
+		sort.Float64s(expected)
+
+		locations, err := repo.Find(ctx, query)
+		assert.Nil(t, err)
+		assert.Len(t, locations, len(expected))
+		for i, location := range locations {
+			assert.Equal(t, expected[i], location.Distance)
+		}
+	}
+}
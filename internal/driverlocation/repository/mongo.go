@@ -2,15 +2,16 @@ package repository
 
 import (
 	"context"
-	"encoding/csv"
 	"os"
-	"strconv"
+	"time"
 
 	"github.com/s3f4/locationmatcher/internal/driverlocation/models"
 	"github.com/s3f4/locationmatcher/pkg/log"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 var (
@@ -50,7 +51,21 @@ func (r *mongoRepository) Find(ctx context.Context, query *models.Query) ([]*mod
 		},
 	}
 
-	cursor, err := collection.Find(ctx, filter)
+	if query.Status != "" {
+		filter = append(filter, bson.E{Key: "status", Value: query.Status})
+	}
+
+	// the TTL monitor runs once a minute, stale documents may still exist
+	if before := expiredBefore(time.Now()); !before.IsZero() {
+		filter = append(filter, bson.E{Key: "updated_at", Value: bson.M{"$gte": before}})
+	}
+
+	opts := options.Find().SetSkip(query.Offset)
+	if query.Limit > 0 {
+		opts.SetLimit(query.Limit)
+	}
+
+	cursor, err := collection.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -79,16 +94,35 @@ func (r *mongoRepository) Find(ctx context.Context, query *models.Query) ([]*mod
 func (r *mongoRepository) Find1(ctx context.Context, query *models.Query) ([]*models.DriverLocation, error) {
 	collection := r.getCollection()
 	coords, _ := query.Location.Coordinates.([]interface{})
-	pipeline := []bson.M{
-		{
-			"$geoNear": bson.M{
-				"key":           "location",
-				"distanceField": "mongo_distance",
-				"maxDistance":   query.MaxDistance,
-				"spherical":     true,
-				"near":          query.Location,
-			},
-		},
+	geoNear := bson.M{
+		"key":           "location",
+		"distanceField": "mongo_distance",
+		"maxDistance":   query.MaxDistance,
+		"spherical":     true,
+		"near":          query.Location,
+	}
+
+	geoNearQuery := bson.M{}
+	if query.Status != "" {
+		geoNearQuery["status"] = query.Status
+	}
+
+	if before := expiredBefore(time.Now()); !before.IsZero() {
+		geoNearQuery["updated_at"] = bson.M{"$gte": before}
+	}
+
+	if len(geoNearQuery) > 0 {
+		geoNear["query"] = geoNearQuery
+	}
+
+	pipeline := []bson.M{{"$geoNear": geoNear}}
+
+	if query.Offset > 0 {
+		pipeline = append(pipeline, bson.M{"$skip": query.Offset})
+	}
+
+	if query.Limit > 0 {
+		pipeline = append(pipeline, bson.M{"$limit": query.Limit})
 	}
 
 	cursor, err := collection.Aggregate(ctx, pipeline)
@@ -120,11 +154,28 @@ func (r *mongoRepository) Find1(ctx context.Context, query *models.Query) ([]*mo
 	return driverLocations, nil
 }
 
-func (r *mongoRepository) UpsertBulk(ctx context.Context, driverLocations []*models.DriverLocation) error {
+// UpsertBulk upserts driver locations by driver id, or by id if the driver
+// id is empty, in one unordered bulk write. Write errors fail only their
+// driver locations.
+func (r *mongoRepository) UpsertBulk(ctx context.Context, driverLocations []*models.DriverLocation) ([]*models.UpsertResult, error) {
 	collection := r.getCollection()
 
-	models := []mongo.WriteModel{}
-	for _, driverLocation := range driverLocations {
+	now := time.Now().UTC()
+	results := make([]*models.UpsertResult, len(driverLocations))
+	writeModels := []mongo.WriteModel{}
+	// indexes holds the driver location index of every write model
+	indexes := []int{}
+	for i, driverLocation := range driverLocations {
+		if _, err := driverLocation.Coordinates(); err != nil {
+			results[i] = models.NewUpsertResult(driverLocation, models.UpsertFailed, err)
+			continue
+		}
+
+		if driverLocation.ID.IsZero() {
+			driverLocation.ID = primitive.NewObjectID()
+		}
+		driverLocation.Touch(now)
+
 		document := bson.D{
 			{
 				Key: "location", Value: bson.D{
@@ -132,25 +183,114 @@ func (r *mongoRepository) UpsertBulk(ctx context.Context, driverLocations []*mod
 					{Key: "coordinates", Value: driverLocation.Location.Coordinates},
 				},
 			},
+			{Key: "updated_at", Value: driverLocation.UpdatedAt},
 		}
 
-		if !driverLocation.ID.IsZero() {
-			models = append(models,
-				mongo.NewUpdateOneModel().
-					SetFilter(bson.M{"_id": driverLocation.ID}).
-					SetUpdate(bson.D{
-						{Key: "$set", Value: document},
-					}),
-			)
+		// an empty status keeps the stored status, new drivers are available
+		onInsert := bson.M{}
+		if driverLocation.Status != "" {
+			document = append(document, bson.E{Key: "status", Value: driverLocation.Status})
 		} else {
-			models = append(models, mongo.NewInsertOneModel().SetDocument(document))
+			onInsert["status"] = models.StatusAvailable
 		}
+
+		filter := bson.M{"_id": driverLocation.ID}
+		if driverLocation.DriverID != "" {
+			// the id is only used if the driver does not have a document yet
+			filter = bson.M{"driver_id": driverLocation.DriverID}
+			onInsert["_id"] = driverLocation.ID
+			onInsert["driver_id"] = driverLocation.DriverID
+		}
+
+		update := bson.D{{Key: "$set", Value: document}}
+		if len(onInsert) > 0 {
+			update = append(update, bson.E{Key: "$setOnInsert", Value: onInsert})
+		}
+
+		writeModels = append(writeModels, mongo.NewUpdateOneModel().
+			SetFilter(filter).
+			SetUpdate(update).
+			SetUpsert(true),
+		)
+		indexes = append(indexes, i)
+	}
+
+	if len(writeModels) == 0 {
+		return results, nil
 	}
 
 	opts := options.BulkWrite().SetOrdered(false)
-	res, err := collection.BulkWrite(ctx, models, opts)
-	log.Infof("%#v\n", res)
-	return err
+	res, err := collection.BulkWrite(ctx, writeModels, opts)
+	failed := map[int]error{}
+	if err != nil {
+		bulkErr, ok := err.(mongo.BulkWriteException)
+		if !ok || bulkErr.WriteConcernError != nil {
+			return nil, err
+		}
+
+		for _, writeErr := range bulkErr.WriteErrors {
+			failed[writeErr.Index] = writeErr
+		}
+	}
+
+	updatedDrivers := []string{}
+	for j, i := range indexes {
+		driverLocation := driverLocations[i]
+		switch _, upserted := res.UpsertedIDs[int64(j)]; {
+		case failed[j] != nil:
+			results[i] = models.NewUpsertResult(driverLocation, models.UpsertFailed, failed[j])
+		case upserted:
+			results[i] = models.NewUpsertResult(driverLocation, models.UpsertInserted, nil)
+		default:
+			results[i] = models.NewUpsertResult(driverLocation, models.UpsertUpdated, nil)
+			if driverLocation.DriverID != "" {
+				updatedDrivers = append(updatedDrivers, driverLocation.DriverID)
+			}
+		}
+	}
+
+	// updated drivers keep the id of their existing document
+	if len(updatedDrivers) > 0 {
+		if err := r.resolveIDs(ctx, updatedDrivers, driverLocations, results); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// resolveIDs sets the ids of driver locations and results of the given
+// drivers to the ids of their documents.
+func (r *mongoRepository) resolveIDs(ctx context.Context, driverIDs []string, driverLocations []*models.DriverLocation, results []*models.UpsertResult) error {
+	cursor, err := r.getCollection().Find(ctx,
+		bson.M{"driver_id": bson.M{"$in": driverIDs}},
+		options.Find().SetProjection(bson.M{"_id": 1, "driver_id": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	ids := map[string]primitive.ObjectID{}
+	for cursor.Next(ctx) {
+		var document struct {
+			ID       primitive.ObjectID `bson:"_id"`
+			DriverID string             `bson:"driver_id"`
+		}
+		if err := cursor.Decode(&document); err != nil {
+			return err
+		}
+		ids[document.DriverID] = document.ID
+	}
+
+	for i, driverLocation := range driverLocations {
+		if id, ok := ids[driverLocation.DriverID]; ok && results[i].Status == models.UpsertUpdated {
+			driverLocation.ID = id
+			results[i].ID = id
+		}
+	}
+
+	return cursor.Err()
 }
 
 func (r *mongoRepository) DropIfExists(ctx context.Context) error {
@@ -184,47 +324,84 @@ func (r *mongoRepository) CreateIndex(ctx context.Context, key, value string) er
 	return nil
 }
 
-func (r *mongoRepository) Migrate(ctx context.Context) {
-	if err := r.DropIfExists(ctx); err != nil {
-		log.Fatal(err)
+// createDriverIndex creates a unique index on driver_id, documents without
+// a driver id are not indexed.
+func (r *mongoRepository) createDriverIndex(ctx context.Context) error {
+	collection := r.getCollection()
+	model := mongo.IndexModel{
+		Keys:    bson.M{"driver_id": 1},
+		Options: options.Index().SetUnique(true).SetSparse(true),
 	}
-	f, err := os.Open("./source/coordinates.csv")
-	if err != nil {
-		log.Fatal(err)
+
+	_, err := collection.Indexes().CreateOne(ctx, model)
+	return err
+}
+
+// createTTLIndex creates a TTL index on updated_at, mongodb removes
+// driver locations that are not updated for MaxAge.
+func (r *mongoRepository) createTTLIndex(ctx context.Context) error {
+	collection := r.getCollection()
+	model := mongo.IndexModel{
+		Keys:    bson.M{"updated_at": 1},
+		Options: options.Index().SetExpireAfterSeconds(int32(MaxAge.Seconds())),
 	}
-	defer f.Close()
 
-	reader := csv.NewReader(f)
-	reader.Comma = ','
-	rows, _ := reader.ReadAll()
+	_, err := collection.Indexes().CreateOne(ctx, model)
+	return err
+}
 
-	driverLocations := []*models.DriverLocation{}
-	for i, row := range rows {
-		if i == 0 {
-			continue
-		}
+// Ping pings the primary
+func (r *mongoRepository) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx, readpref.Primary())
+}
 
-		driverLocation := &models.DriverLocation{}
-		latitude, err := strconv.ParseFloat(row[0], 64)
+// CheckIndex checks the 2dsphere index of location exists
+func (r *mongoRepository) CheckIndex(ctx context.Context) error {
+	specs, err := r.getCollection().Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, spec := range specs {
+		value, err := spec.KeysDocument.LookupErr("location")
 		if err != nil {
-			log.Fatal(err)
+			continue
 		}
 
-		longitude, err := strconv.ParseFloat(row[1], 64)
-		if err != nil {
-			log.Fatal(err)
+		if indexType, ok := value.StringValueOK(); ok && indexType == "2dsphere" {
+			return nil
 		}
+	}
+
+	return ErrIndexMissing
+}
 
-		driverLocation.Location.Type = "Point"
-		driverLocation.Location.Coordinates = [2]float64{longitude, latitude}
-		driverLocations = append(driverLocations, driverLocation)
+func (r *mongoRepository) Migrate(ctx context.Context) error {
+	if err := r.DropIfExists(ctx); err != nil {
+		return err
+	}
+	driverLocations, err := readSource(sourcePath)
+	if err != nil {
+		return err
+	}
+
+	if err := r.createDriverIndex(ctx); err != nil {
+		return err
 	}
 
-	if err := r.UpsertBulk(ctx, driverLocations); err != nil {
-		log.Fatal(err)
+	if _, err := r.UpsertBulk(ctx, driverLocations); err != nil {
+		return err
 	}
 
 	if err := r.CreateIndex(ctx, "location", "2dsphere"); err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	if MaxAge > 0 {
+		if err := r.createTTLIndex(ctx); err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
@@ -28,6 +28,40 @@ func TestMain(m *testing.M) {
 		log.Fatalf("Could not connect to docker: %s", err)
 	}
 
+	// tests that need a container are skipped when docker is not available
+	resources := []*dockertest.Resource{}
+	for _, start := range []func(*dockertest.Pool) (*dockertest.Resource, error){startMongo, startPostgis} {
+		resource, err := start(pool)
+		if err != nil {
+			log.Warnf("Could not start resource: %s", err)
+			continue
+		}
+		resources = append(resources, resource)
+	}
+
+	// seed data
+	log.Info("Tests are starting...")
+	DB = "driver_location"
+	Collection = "driver_location"
+
+	// Run tests
+	exitCode := m.Run()
+
+	// Teardown
+	// When you're done, kill and remove the containers
+	for _, resource := range resources {
+		if err = pool.Purge(resource); err != nil {
+			log.Fatalf("Could not purge resource: %s", err)
+		}
+	}
+
+	log.Info("Tests Finished")
+	// Exit
+	os.Exit(exitCode)
+}
+
+// startMongo runs a mongodb container and connects db to it
+func startMongo(pool *dockertest.Pool) (*dockertest.Resource, error) {
 	environmentVariables := []string{
 		"MONGO_INITDB_ROOT_USERNAME=root",
 		"MONGO_INITDB_ROOT_PASSWORD=password",
@@ -35,7 +69,7 @@ func TestMain(m *testing.M) {
 
 	resource, err := pool.Run("mongo", "5.0", environmentVariables)
 	if err != nil {
-		log.Fatalf("Could not start resource: %s", err)
+		return nil, err
 	}
 
 	// exponential backoff-retry, because the application in the container might not be ready to accept connections yet
@@ -55,26 +89,14 @@ func TestMain(m *testing.M) {
 		log.Fatalf("Could not connect to docker: %s", err)
 	}
 
-	// seed data
-	log.Info("Tests are starting...")
-	DB = "driver_location"
-	Collection = "driver_location"
-
-	// Run tests
-	exitCode := m.Run()
-
-	// Teardown
-	// When you're done, kill and remove the container
-	if err = pool.Purge(resource); err != nil {
-		log.Fatalf("Could not purge resource: %s", err)
-	}
-
-	log.Info("Tests Finished")
-	// Exit
-	os.Exit(exitCode)
+	return resource, nil
 }
 
 func Test_Mongo(t *testing.T) {
+	if db == nil {
+		t.Skip("mongodb is not available")
+	}
+
 	ctx := context.Background()
 	repo, err := NewRepository("mongo", db)
 	assert.Nil(t, err)
@@ -103,12 +125,16 @@ func Test_Mongo(t *testing.T) {
 		// },
 	}
 	// insert driverLocations
-	err = repo.UpsertBulk(ctx, driverLocations)
+	_, err = repo.UpsertBulk(ctx, driverLocations)
 	// assert error is nil
 	assert.Nil(t, err)
 
+	assert.Nil(t, repo.Ping(ctx))
+	assert.Equal(t, ErrIndexMissing, repo.CheckIndex(ctx))
+
 	err = repo.CreateIndex(ctx, "location", "2dsphere")
 	assert.Nil(t, err)
+	assert.Nil(t, repo.CheckIndex(ctx))
 
 	// it should return ayasofya -> galata
 	locations, err := repo.Find1(ctx, &models.Query{
@@ -145,8 +171,44 @@ func Test_Mongo(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Len(t, locations, 2)
 
+	// limit and offset page the sorted results
+	locations, err = repo.Find1(ctx, &models.Query{
+		Location: models.Location{
+			Type:        "Point",
+			Coordinates: []interface{}{28.9605116156308, 41.01189519061322},
+		},
+		MinDistance: 0,
+		MaxDistance: 10000,
+		Limit:       1,
+		Offset:      1,
+	})
+
+	assert.Nil(t, err)
+	assert.Len(t, locations, 1)
+
+	// drivers without a status are stored as available
+	locations, err = repo.Find(ctx, &models.Query{
+		Location: models.Location{
+			Type:        "Point",
+			Coordinates: []interface{}{28.9605116156308, 41.01189519061322},
+		},
+		MinDistance: 0,
+		MaxDistance: 10000,
+		Status:      models.StatusBusy,
+	})
+
+	assert.Nil(t, err)
+	assert.Len(t, locations, 0)
+
 	err = repo.DropIfExists(ctx)
 	assert.Nil(t, err)
+
+	err = repo.(*mongoRepository).createDriverIndex(ctx)
+	assert.Nil(t, err)
+	err = repo.CreateIndex(ctx, "location", "2dsphere")
+	assert.Nil(t, err)
+	testUpsertByDriverID(ctx, t, repo)
+	testIDConflict(ctx, t, repo)
 }
 
 // func Test_MongoRepository_Find(t *testing.T) {
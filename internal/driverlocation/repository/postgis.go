@@ -0,0 +1,328 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/s3f4/locationmatcher/internal/driverlocation/models"
+	"github.com/s3f4/locationmatcher/pkg/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// Table is the postgres table of driver locations
+	Table = os.Getenv("DRIVER_LOCATION_TABLE")
+)
+
+// postgisRepository stores driver locations as geography points, distances
+// are calculated on the spheroid by postgis.
+type postgisRepository struct {
+	pool *pgxpool.Pool
+}
+
+// table returns the quoted table name
+func (r *postgisRepository) table() string {
+	return pgx.Identifier{Table}.Sanitize()
+}
+
+// Find returns driver locations between min and max distance sorted by distance.
+func (r *postgisRepository) Find(ctx context.Context, query *models.Query) ([]*models.DriverLocation, error) {
+	return r.search(ctx, query, query.MinDistance, false)
+}
+
+// Find1 returns driver locations within max distance sorted by distance,
+// MongoDistance holds the distance calculated by postgis in kilometers
+// like the $geoNear stage of mongoRepository.
+func (r *postgisRepository) Find1(ctx context.Context, query *models.Query) ([]*models.DriverLocation, error) {
+	return r.search(ctx, query, 0, true)
+}
+
+func (r *postgisRepository) search(ctx context.Context, query *models.Query, minDistance int64, setDistance bool) ([]*models.DriverLocation, error) {
+	coords, _ := query.Location.Coordinates.([]interface{})
+	longitude := coords[0].(float64)
+	latitude := coords[1].(float64)
+
+	// ST_DWithin uses the gist index, <-> orders by the index (KNN)
+	sql := fmt.Sprintf(`
+		WITH point AS (
+			SELECT ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography AS location
+		)
+		SELECT t.id, COALESCE(t.driver_id, ''), t.status, t.updated_at,
+			ST_X(t.location::geometry), ST_Y(t.location::geometry), ST_Distance(t.location, point.location)
+		FROM %s t, point
+		WHERE ST_DWithin(t.location, point.location, $3)
+			AND ST_Distance(t.location, point.location) >= $4
+			AND ($7::text = '' OR t.status = $7)
+			AND ($8::timestamptz IS NULL OR t.updated_at >= $8)
+		ORDER BY t.location <-> point.location
+		LIMIT $5 OFFSET $6`, r.table())
+
+	// LIMIT NULL returns all rows
+	var limit interface{}
+	if query.Limit > 0 {
+		limit = query.Limit
+	}
+
+	// a NULL update time returns stale rows too
+	var updatedAfter interface{}
+	if before := expiredBefore(time.Now()); !before.IsZero() {
+		updatedAfter = before
+	}
+
+	rows, err := r.pool.Query(ctx, sql, longitude, latitude, query.MaxDistance, minDistance,
+		limit, query.Offset, string(query.Status), updatedAfter)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	driverLocations := []*models.DriverLocation{}
+	for rows.Next() {
+		var id, driverID, driverStatus string
+		var updatedAt time.Time
+		var lng, lat, distance float64
+		if err := rows.Scan(&id, &driverID, &driverStatus, &updatedAt, &lng, &lat, &distance); err != nil {
+			log.Error("Could not decode driver location")
+			return nil, err
+		}
+
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, err
+		}
+
+		driverLocation := &models.DriverLocation{
+			ID:        objectID,
+			DriverID:  driverID,
+			Status:    models.DriverStatus(driverStatus),
+			UpdatedAt: updatedAt.UTC(),
+			Location: models.Location{
+				Type:        "Point",
+				Coordinates: []float64{lng, lat},
+			},
+		}
+
+		if setDistance {
+			pDistance := distance / 1000
+			driverLocation.MongoDistance = &pDistance
+		}
+
+		driverLocation.Distance, err = driverLocation.CalculateDistance(latitude, longitude)
+		if err != nil {
+			return nil, err
+		}
+
+		driverLocations = append(driverLocations, driverLocation)
+	}
+
+	return driverLocations, rows.Err()
+}
+
+// UpsertBulk inserts driver locations or updates existing ones by driver id,
+// or by id if the driver id is empty, in one batch. Driver locations with
+// invalid coordinates or with the id of another driver fail, database errors
+// fail the whole batch.
+func (r *postgisRepository) UpsertBulk(ctx context.Context, driverLocations []*models.DriverLocation) ([]*models.UpsertResult, error) {
+	// xmax is 0 for inserted rows, an empty status keeps the stored status
+	// and new drivers are available
+	onConflict := `
+		ON CONFLICT (%[2]s) DO UPDATE SET
+			driver_id = COALESCE(EXCLUDED.driver_id, %[1]s.driver_id),
+			status = COALESCE(NULLIF($3, ''), %[1]s.status),
+			updated_at = EXCLUDED.updated_at,
+			location = EXCLUDED.location
+		RETURNING id, status, xmax = 0`
+	upsertByID := fmt.Sprintf(`
+		INSERT INTO %[1]s (id, driver_id, status, updated_at, location)
+		VALUES ($1, NULLIF($2, ''), COALESCE(NULLIF($3, ''), 'available'), $4, ST_SetSRID(ST_MakePoint($5, $6), 4326)::geography)`+onConflict,
+		r.table(), "id")
+	// new drivers are not inserted when their id is the id of another row,
+	// no row is returned instead of failing the transaction
+	upsertByDriverID := fmt.Sprintf(`
+		INSERT INTO %[1]s (id, driver_id, status, updated_at, location)
+		SELECT $1::text, $2::text, COALESCE(NULLIF($3::text, ''), 'available'), $4::timestamptz, ST_SetSRID(ST_MakePoint($5, $6), 4326)::geography
+		WHERE EXISTS (SELECT 1 FROM %[1]s WHERE driver_id = $2)
+			OR NOT EXISTS (SELECT 1 FROM %[1]s WHERE id = $1)`+onConflict,
+		r.table(), "driver_id")
+
+	now := time.Now().UTC()
+	results := make([]*models.UpsertResult, len(driverLocations))
+	valid := make([]int, 0, len(driverLocations))
+	batch := &pgx.Batch{}
+	for i, driverLocation := range driverLocations {
+		coordinates, err := driverLocation.Coordinates()
+		if err != nil {
+			results[i] = models.NewUpsertResult(driverLocation, models.UpsertFailed, err)
+			continue
+		}
+
+		if driverLocation.ID.IsZero() {
+			driverLocation.ID = primitive.NewObjectID()
+		}
+		driverLocation.Touch(now)
+
+		sql := upsertByID
+		if driverLocation.DriverID != "" {
+			sql = upsertByDriverID
+		}
+
+		batch.Queue(sql, driverLocation.ID.Hex(), driverLocation.DriverID, string(driverLocation.Status),
+			driverLocation.UpdatedAt, coordinates[0], coordinates[1])
+		valid = append(valid, i)
+	}
+
+	if batch.Len() == 0 {
+		return results, nil
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	batchResults := tx.SendBatch(ctx, batch)
+	for _, i := range valid {
+		var id, driverStatus string
+		var inserted bool
+		err := batchResults.QueryRow().Scan(&id, &driverStatus, &inserted)
+		if err == pgx.ErrNoRows {
+			results[i] = models.NewUpsertResult(driverLocations[i], models.UpsertFailed, ErrIDConflict)
+			continue
+		}
+		if err != nil {
+			batchResults.Close()
+			return nil, err
+		}
+
+		// updated drivers keep the id of their row
+		driverLocation := driverLocations[i]
+		if driverLocation.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+			batchResults.Close()
+			return nil, err
+		}
+		driverLocation.Status = models.DriverStatus(driverStatus)
+
+		status := models.UpsertUpdated
+		if inserted {
+			status = models.UpsertInserted
+		}
+		results[i] = models.NewUpsertResult(driverLocation, status, nil)
+	}
+
+	if err := batchResults.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// expire deletes driver locations updated before the given time
+func (r *postgisRepository) expire(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := r.pool.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE updated_at < $1", r.table()), before)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// DropIfExists drops the driver locations table
+func (r *postgisRepository) DropIfExists(ctx context.Context) error {
+	_, err := r.pool.Exec(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", r.table()))
+	return err
+}
+
+// CreateIndex creates an index on the given column with the given method,
+// e.g. location => gist.
+func (r *postgisRepository) CreateIndex(ctx context.Context, key, value string) error {
+	name := pgx.Identifier{Table + "_" + key + "_idx"}.Sanitize()
+	sql := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING %s (%s)",
+		name, r.table(), pgx.Identifier{value}.Sanitize(), pgx.Identifier{key}.Sanitize())
+
+	_, err := r.pool.Exec(ctx, sql)
+	return err
+}
+
+// Ping acquires a connection and pings postgres
+func (r *postgisRepository) Ping(ctx context.Context) error {
+	return r.pool.Ping(ctx)
+}
+
+// CheckIndex checks the gist index of location exists
+func (r *postgisRepository) CheckIndex(ctx context.Context) error {
+	var exists bool
+	err := r.pool.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM pg_indexes WHERE tablename = $1 AND indexname = $2)",
+		Table, Table+"_location_idx").Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return ErrIndexMissing
+	}
+	return nil
+}
+
+// createTable creates the postgis extension and the driver locations table
+func (r *postgisRepository) createTable(ctx context.Context) error {
+	if _, err := r.pool.Exec(ctx, "CREATE EXTENSION IF NOT EXISTS postgis"); err != nil {
+		return err
+	}
+
+	_, err := r.pool.Exec(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			id CHAR(24) PRIMARY KEY,
+			driver_id TEXT UNIQUE,
+			status TEXT NOT NULL DEFAULT 'available',
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			location GEOGRAPHY(POINT, 4326) NOT NULL
+		)`, r.table()))
+	return err
+}
+
+func (r *postgisRepository) Migrate(ctx context.Context) error {
+	if err := r.DropIfExists(ctx); err != nil {
+		return err
+	}
+
+	if err := r.createTable(ctx); err != nil {
+		return err
+	}
+
+	driverLocations, err := readSource(sourcePath)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(driverLocations); i += migrateBatchSize {
+		end := i + migrateBatchSize
+		if end > len(driverLocations) {
+			end = len(driverLocations)
+		}
+
+		if _, err := r.UpsertBulk(ctx, driverLocations[i:end]); err != nil {
+			return err
+		}
+	}
+
+	if err := r.CreateIndex(ctx, "location", "gist"); err != nil {
+		return err
+	}
+
+	// used by the expiry sweep
+	if err := r.CreateIndex(ctx, "updated_at", "btree"); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,391 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/s3f4/locationmatcher/internal/driverlocation/models"
+	"github.com/s3f4/locationmatcher/pkg/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// Key is the redis key of the driver locations geo set
+	Key = os.Getenv("DRIVER_LOCATION_KEY")
+)
+
+// redisRepository keeps driver locations in a redis geo set, members are
+// the hex ids of driver locations. Driver data is kept in a hash next to
+// the geo set since members of a geo set only have coordinates.
+type redisRepository struct {
+	client *redis.Client
+}
+
+// redisDriver is the value of a driver location in the drivers hash
+type redisDriver struct {
+	DriverID  string              `json:"driver_id,omitempty"`
+	Status    models.DriverStatus `json:"status,omitempty"`
+	UpdatedAt time.Time           `json:"updated_at"`
+}
+
+// driversKey returns the key of the hash that holds driver data by member
+func driversKey() string {
+	return Key + ":drivers"
+}
+
+// driverIDsKey returns the key of the hash that holds members by driver id
+func driverIDsKey() string {
+	return Key + ":driver_ids"
+}
+
+// updatedKey returns the key of the sorted set that holds members by their
+// update time in milliseconds, it is used to find stale members.
+func updatedKey() string {
+	return Key + ":updated_at"
+}
+
+// Find returns driver locations between min and max distance sorted by distance.
+func (r *redisRepository) Find(ctx context.Context, query *models.Query) ([]*models.DriverLocation, error) {
+	return r.search(ctx, query, query.MinDistance, false)
+}
+
+// Find1 returns driver locations within max distance sorted by distance,
+// MongoDistance holds the distance calculated by redis in kilometers
+// like the $geoNear stage of mongoRepository.
+func (r *redisRepository) Find1(ctx context.Context, query *models.Query) ([]*models.DriverLocation, error) {
+	return r.search(ctx, query, 0, true)
+}
+
+func (r *redisRepository) search(ctx context.Context, query *models.Query, minDistance int64, setDistance bool) ([]*models.DriverLocation, error) {
+	coords, _ := query.Location.Coordinates.([]interface{})
+	longitude := coords[0].(float64)
+	latitude := coords[1].(float64)
+
+	// COUNT can only be used when all results within the radius are returned,
+	// results closer than minDistance, with another status or stale are filtered afterwards
+	before := expiredBefore(time.Now())
+	count := 0
+	if minDistance == 0 && query.Status == "" && before.IsZero() && query.Limit > 0 {
+		count = int(query.Offset + query.Limit)
+	}
+
+	locations, err := r.client.GeoSearchLocation(ctx, Key, &redis.GeoSearchLocationQuery{
+		GeoSearchQuery: redis.GeoSearchQuery{
+			Longitude:  longitude,
+			Latitude:   latitude,
+			Radius:     float64(query.MaxDistance),
+			RadiusUnit: "m",
+			Sort:       "ASC",
+			Count:      count,
+		},
+		WithCoord: true,
+		WithDist:  true,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]string, 0, len(locations))
+	for _, location := range locations {
+		members = append(members, location.Name)
+	}
+
+	drivers, err := r.drivers(ctx, members)
+	if err != nil {
+		return nil, err
+	}
+
+	driverLocations := []*models.DriverLocation{}
+	for i, location := range locations {
+		if location.Dist < float64(minDistance) {
+			continue
+		}
+
+		driver := drivers[i]
+		if query.Status != "" && driver.Status != query.Status {
+			continue
+		}
+
+		if driver.UpdatedAt.Before(before) {
+			continue
+		}
+
+		id, err := primitive.ObjectIDFromHex(location.Name)
+		if err != nil {
+			log.Error("Could not decode driver location id")
+			return nil, err
+		}
+
+		driverLocation := &models.DriverLocation{
+			ID:        id,
+			DriverID:  driver.DriverID,
+			Status:    driver.Status,
+			UpdatedAt: driver.UpdatedAt,
+			Location: models.Location{
+				Type:        "Point",
+				Coordinates: []float64{location.Longitude, location.Latitude},
+			},
+		}
+
+		if setDistance {
+			rDistance := location.Dist / 1000
+			driverLocation.MongoDistance = &rDistance
+		}
+
+		driverLocation.Distance, err = driverLocation.CalculateDistance(latitude, longitude)
+		if err != nil {
+			return nil, err
+		}
+
+		driverLocations = append(driverLocations, driverLocation)
+	}
+
+	return paginate(driverLocations, query), nil
+}
+
+// drivers returns the driver data of the given members in the same order,
+// members without data get an empty value.
+func (r *redisRepository) drivers(ctx context.Context, members []string) ([]redisDriver, error) {
+	drivers := make([]redisDriver, len(members))
+	if len(members) == 0 {
+		return drivers, nil
+	}
+
+	values, err := r.client.HMGet(ctx, driversKey(), members...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		if err := json.Unmarshal([]byte(data), &drivers[i]); err != nil {
+			log.Error("Could not decode driver")
+			return nil, err
+		}
+	}
+
+	return drivers, nil
+}
+
+// upsertScript resolves the members of driver locations and writes them in
+// one step, so concurrent upserts of a new driver share one member. KEYS are
+// the geo set, the drivers hash, the driver ids hash and the update times.
+// ARGV holds the update time and its score followed by member, driver id,
+// status, longitude and latitude groups, a member is replaced by the member
+// of its driver when the driver is known. It returns member, result, driver
+// id and status groups, the result is inserted, updated or conflict.
+var upsertScript = redis.NewScript(`
+local results = {}
+for i = 3, #ARGV, 5 do
+	local member, driverID, status = ARGV[i], ARGV[i + 1], ARGV[i + 2]
+	if driverID ~= '' then
+		member = redis.call('HGET', KEYS[3], driverID) or member
+	end
+
+	local result = 'inserted'
+	local stored = redis.call('HGET', KEYS[2], member)
+	if stored then
+		stored = cjson.decode(stored)
+		local storedDriverID = stored.driver_id or ''
+		result = 'updated'
+		-- updates by id keep the driver of the member
+		if driverID == '' then
+			driverID = storedDriverID
+		elseif driverID ~= storedDriverID then
+			result = 'conflict'
+		end
+		if status == '' then
+			status = stored.status or ''
+		end
+	end
+
+	if result ~= 'conflict' then
+		if status == '' then
+			status = 'available'
+		end
+		local driver = {status = status, updated_at = ARGV[1]}
+		if driverID ~= '' then
+			driver.driver_id = driverID
+			redis.call('HSET', KEYS[3], driverID, member)
+		end
+		redis.call('GEOADD', KEYS[1], ARGV[i + 3], ARGV[i + 4], member)
+		redis.call('HSET', KEYS[2], member, cjson.encode(driver))
+		redis.call('ZADD', KEYS[4], ARGV[2], member)
+	end
+
+	table.insert(results, member)
+	table.insert(results, result)
+	table.insert(results, driverID)
+	table.insert(results, status)
+end
+return results
+`)
+
+// UpsertBulk adds driver locations to the geo set and their driver data to
+// the drivers hash with upsertScript, GEOADD updates the position of
+// existing members. Driver locations are keyed by driver id, or by id if the
+// driver id is empty, driver locations with invalid coordinates or with the
+// id of another driver fail.
+func (r *redisRepository) UpsertBulk(ctx context.Context, driverLocations []*models.DriverLocation) ([]*models.UpsertResult, error) {
+	now := time.Now().UTC()
+	results := make([]*models.UpsertResult, len(driverLocations))
+	valid := make([]int, 0, len(driverLocations))
+	args := []interface{}{now.Format(time.RFC3339Nano), now.UnixMilli()}
+	for i, driverLocation := range driverLocations {
+		coordinates, err := driverLocation.Coordinates()
+		if err != nil {
+			results[i] = models.NewUpsertResult(driverLocation, models.UpsertFailed, err)
+			continue
+		}
+
+		if driverLocation.ID.IsZero() {
+			driverLocation.ID = primitive.NewObjectID()
+		}
+		driverLocation.Touch(now)
+
+		args = append(args, driverLocation.ID.Hex(), driverLocation.DriverID, string(driverLocation.Status),
+			strconv.FormatFloat(coordinates[0], 'f', -1, 64), strconv.FormatFloat(coordinates[1], 'f', -1, 64))
+		valid = append(valid, i)
+	}
+
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	keys := []string{Key, driversKey(), driverIDsKey(), updatedKey()}
+	values, err := upsertScript.Run(ctx, r.client, keys, args...).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+
+	for j, i := range valid {
+		driverLocation := driverLocations[i]
+		member, result := values[4*j], values[4*j+1]
+		if result == "conflict" {
+			results[i] = models.NewUpsertResult(driverLocation, models.UpsertFailed, ErrIDConflict)
+			continue
+		}
+
+		if driverLocation.ID, err = primitive.ObjectIDFromHex(member); err != nil {
+			log.Error("Could not decode driver location id")
+			return nil, err
+		}
+		driverLocation.DriverID = values[4*j+2]
+		driverLocation.Status = models.DriverStatus(values[4*j+3])
+
+		status := models.UpsertInserted
+		if result == "updated" {
+			status = models.UpsertUpdated
+		}
+		results[i] = models.NewUpsertResult(driverLocation, status, nil)
+	}
+
+	return results, nil
+}
+
+// expireScript removes the members of ARGV that are still updated before
+// the score ARGV[1], members that were updated after they were read are kept.
+// KEYS are the geo set, the drivers hash, the driver ids hash and the update
+// times, ARGV holds member and driver id pairs after the score.
+var expireScript = redis.NewScript(`
+local removed = 0
+for i = 2, #ARGV, 2 do
+	local member, driverID = ARGV[i], ARGV[i + 1]
+	local score = redis.call('ZSCORE', KEYS[4], member)
+	if score and tonumber(score) < tonumber(ARGV[1]) then
+		redis.call('ZREM', KEYS[1], member)
+		redis.call('HDEL', KEYS[2], member)
+		redis.call('ZREM', KEYS[4], member)
+		if driverID ~= '' and redis.call('HGET', KEYS[3], driverID) == member then
+			redis.call('HDEL', KEYS[3], driverID)
+		end
+		removed = removed + 1
+	end
+end
+return removed
+`)
+
+// expire removes members updated before the given time from the geo set,
+// the drivers hash and the update times.
+func (r *redisRepository) expire(ctx context.Context, before time.Time) (int64, error) {
+	members, err := r.client.ZRangeByScore(ctx, updatedKey(), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: "(" + strconv.FormatInt(before.UnixMilli(), 10),
+	}).Result()
+	if err != nil || len(members) == 0 {
+		return 0, err
+	}
+
+	drivers, err := r.drivers(ctx, members)
+	if err != nil {
+		return 0, err
+	}
+
+	return r.removeExpired(ctx, before, members, drivers)
+}
+
+// removeExpired removes the members that are still updated before the given
+// time, drivers are the stored drivers of members.
+func (r *redisRepository) removeExpired(ctx context.Context, before time.Time, members []string, drivers []redisDriver) (int64, error) {
+	args := make([]interface{}, 0, 1+2*len(members))
+	args = append(args, before.UnixMilli())
+	for i, member := range members {
+		args = append(args, member, drivers[i].DriverID)
+	}
+
+	keys := []string{Key, driversKey(), driverIDsKey(), updatedKey()}
+	return expireScript.Run(ctx, r.client, keys, args...).Int64()
+}
+
+// DropIfExists deletes the geo set, the drivers hashes and the update times
+func (r *redisRepository) DropIfExists(ctx context.Context) error {
+	return r.client.Del(ctx, Key, driversKey(), driverIDsKey(), updatedKey()).Err()
+}
+
+// CreateIndex does nothing, the geo set is the index
+func (r *redisRepository) CreateIndex(context.Context, string, string) error {
+	return nil
+}
+
+// Ping pings redis
+func (r *redisRepository) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+// CheckIndex does nothing, the geo set is the index and it is created by the
+// first upsert
+func (r *redisRepository) CheckIndex(context.Context) error {
+	return nil
+}
+
+func (r *redisRepository) Migrate(ctx context.Context) error {
+	if err := r.DropIfExists(ctx); err != nil {
+		return err
+	}
+
+	driverLocations, err := readSource(sourcePath)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(driverLocations); i += migrateBatchSize {
+		end := i + migrateBatchSize
+		if end > len(driverLocations) {
+			end = len(driverLocations)
+		}
+
+		if _, err := r.UpsertBulk(ctx, driverLocations[i:end]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
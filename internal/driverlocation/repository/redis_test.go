@@ -0,0 +1,259 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/go-redis/redis/v8"
+	"github.com/s3f4/locationmatcher/internal/driverlocation/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// newMiniredis starts an in-process redis, miniredis does not support
+// GEOSEARCH so it is registered as GEORADIUS with the same reply.
+func newMiniredis(t *testing.T) *miniredis.Miniredis {
+	m := miniredis.RunT(t)
+	proxy := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { proxy.Close() })
+
+	// GEOSEARCH key FROMLONLAT lon lat BYRADIUS radius unit [ASC|DESC] [COUNT n] [WITHCOORD] [WITHDIST]
+	err := m.Server().Register("GEOSEARCH", func(c *server.Peer, cmd string, args []string) {
+		if len(args) < 7 || strings.ToUpper(args[1]) != "FROMLONLAT" || strings.ToUpper(args[4]) != "BYRADIUS" {
+			c.WriteError("ERR syntax error")
+			return
+		}
+
+		georadius := []interface{}{"GEORADIUS", args[0], args[2], args[3], args[5], args[6]}
+		for _, arg := range args[7:] {
+			georadius = append(georadius, arg)
+		}
+
+		reply, err := proxy.Do(context.Background(), georadius...).Result()
+		if err != nil {
+			c.WriteError(err.Error())
+			return
+		}
+		writeReply(c, reply)
+	})
+	assert.Nil(t, err)
+
+	return m
+}
+
+func writeReply(c *server.Peer, reply interface{}) {
+	switch v := reply.(type) {
+	case string:
+		c.WriteBulk(v)
+	case int64:
+		c.WriteInt(int(v))
+	case []interface{}:
+		c.WriteLen(len(v))
+		for _, item := range v {
+			writeReply(c, item)
+		}
+	}
+}
+
+func Test_Redis(t *testing.T) {
+	ctx := context.Background()
+	Key = "driver_location"
+
+	m := newMiniredis(t)
+	client, err := connectRedis("redis://" + m.Addr())
+	assert.Nil(t, err)
+
+	repo, err := NewRepository("redis", client)
+	assert.Nil(t, err)
+	assert.Nil(t, repo.Ping(ctx))
+	assert.Nil(t, repo.CheckIndex(ctx))
+
+	driverLocations := []*models.DriverLocation{
+		// galata
+		{
+			Location: models.Location{
+				Type:        "Point",
+				Coordinates: []interface{}{28.97413088610361, 41.025651081666744},
+			},
+		},
+		// ayasofya
+		{
+			Location: models.Location{
+				Type:        "Point",
+				Coordinates: []interface{}{28.979986854317975, 41.00858654897259},
+			},
+		},
+	}
+
+	results, err := repo.UpsertBulk(ctx, driverLocations)
+	assert.Nil(t, err)
+	assert.Equal(t, models.UpsertInserted, results[0].Status)
+	assert.False(t, driverLocations[0].ID.IsZero())
+
+	// it should return ayasofya -> galata
+	query := &models.Query{
+		Location: models.Location{
+			Type:        "Point",
+			Coordinates: []interface{}{28.9605116156308, 41.01189519061322},
+		},
+		MinDistance: 0,
+		MaxDistance: 10000,
+	}
+
+	locations, err := repo.Find1(ctx, query)
+	assert.Nil(t, err)
+	assert.Len(t, locations, 2)
+	assert.Equal(t, driverLocations[1].ID, locations[0].ID)
+	assert.Equal(t, driverLocations[0].ID, locations[1].ID)
+	assert.NotNil(t, locations[0].MongoDistance)
+	assert.InDelta(t, locations[0].Distance, *locations[0].MongoDistance, 0.01)
+
+	// limit and offset page the sorted results
+	query.Limit, query.Offset = 1, 1
+	locations, err = repo.Find1(ctx, query)
+	assert.Nil(t, err)
+	assert.Len(t, locations, 1)
+	assert.Equal(t, driverLocations[0].ID, locations[0].ID)
+	query.Limit, query.Offset = 0, 0
+
+	// only drivers with the queried status are returned
+	driverLocations[1].Status = models.StatusBusy
+	_, err = repo.UpsertBulk(ctx, driverLocations[1:2])
+	assert.Nil(t, err)
+
+	query.Status = models.StatusAvailable
+	locations, err = repo.Find1(ctx, query)
+	assert.Nil(t, err)
+	assert.Len(t, locations, 1)
+	assert.Equal(t, driverLocations[0].ID, locations[0].ID)
+	assert.Equal(t, models.StatusAvailable, locations[0].Status)
+	assert.False(t, locations[0].UpdatedAt.IsZero())
+	query.Status = ""
+
+	// ayasofya is closer than minDistance
+	query.MinDistance = 1700
+	locations, err = repo.Find(ctx, query)
+	assert.Nil(t, err)
+	assert.Len(t, locations, 1)
+	assert.Equal(t, driverLocations[0].ID, locations[0].ID)
+	assert.Nil(t, locations[0].MongoDistance)
+
+	// upsert with an id moves the driver location
+	driverLocations[0].Location.Coordinates = []interface{}{29.5, 41.5}
+	results, err = repo.UpsertBulk(ctx, driverLocations[:1])
+	assert.Nil(t, err)
+	assert.Equal(t, models.UpsertUpdated, results[0].Status)
+
+	locations, err = repo.Find(ctx, query)
+	assert.Nil(t, err)
+	assert.Len(t, locations, 0)
+
+	// stale driver locations are not returned and removed by expire
+	MaxAge = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+	query.MinDistance = 0
+	locations, err = repo.Find1(ctx, query)
+	assert.Nil(t, err)
+	assert.Len(t, locations, 0)
+	MaxAge = 0
+
+	removed, err := repo.(expirer).expire(ctx, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), removed)
+
+	locations, err = repo.Find1(ctx, query)
+	assert.Nil(t, err)
+	assert.Len(t, locations, 0)
+
+	err = repo.DropIfExists(ctx)
+	assert.Nil(t, err)
+	assert.False(t, m.Exists(Key))
+
+	testUpsertByDriverID(ctx, t, repo)
+	assert.False(t, m.Exists(driverIDsKey()))
+	testIDConflict(ctx, t, repo)
+}
+
+func Test_Redis_ClientType(t *testing.T) {
+	_, err := NewRepository("redis", nil)
+	assert.Equal(t, ErrClientType, err)
+}
+
+func Test_Redis_ExpireKeepsRefreshed(t *testing.T) {
+	ctx := context.Background()
+	Key = "driver_location"
+
+	m := newMiniredis(t)
+	client, err := connectRedis("redis://" + m.Addr())
+	assert.Nil(t, err)
+	repo := &redisRepository{client: client}
+
+	driverLocations := []*models.DriverLocation{
+		{DriverID: "driver-1", Location: models.Location{Type: "Point", Coordinates: []interface{}{28.97413088610361, 41.025651081666744}}},
+		{DriverID: "driver-2", Location: models.Location{Type: "Point", Coordinates: []interface{}{28.979986854317975, 41.00858654897259}}},
+	}
+	_, err = repo.UpsertBulk(ctx, driverLocations)
+	assert.Nil(t, err)
+
+	members := []string{driverLocations[0].ID.Hex(), driverLocations[1].ID.Hex()}
+	drivers, err := repo.drivers(ctx, members)
+	assert.Nil(t, err)
+
+	// both members are stale when they are read, driver-1 upserts before
+	// they are removed
+	time.Sleep(5 * time.Millisecond)
+	before := time.Now()
+	time.Sleep(5 * time.Millisecond)
+	_, err = repo.UpsertBulk(ctx, driverLocations[:1])
+	assert.Nil(t, err)
+
+	removed, err := repo.removeExpired(ctx, before, members, drivers)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), removed)
+
+	assert.True(t, m.Exists(driversKey()))
+	id, err := client.HGet(ctx, driverIDsKey(), "driver-1").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, members[0], id)
+	assert.False(t, client.HExists(ctx, driverIDsKey(), "driver-2").Val())
+	assert.Equal(t, []string{members[0]}, client.ZRange(ctx, updatedKey(), 0, -1).Val())
+	assert.Equal(t, []string{members[0]}, client.ZRange(ctx, Key, 0, -1).Val())
+}
+
+func Test_Redis_ConcurrentNewDriver(t *testing.T) {
+	ctx := context.Background()
+	Key = "driver_location"
+
+	m := newMiniredis(t)
+	client, err := connectRedis("redis://" + m.Addr())
+	assert.Nil(t, err)
+	repo := &redisRepository{client: client}
+
+	// first upserts of a driver share one member
+	var wg sync.WaitGroup
+	ids := make([]string, 10)
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			driverLocation := &models.DriverLocation{
+				DriverID: "driver-1",
+				Location: models.Location{Type: "Point", Coordinates: []interface{}{28.97413088610361, 41.025651081666744}},
+			}
+			results, err := repo.UpsertBulk(ctx, []*models.DriverLocation{driverLocation})
+			assert.Nil(t, err)
+			ids[i] = results[0].ID.Hex()
+		}(i)
+	}
+	wg.Wait()
+
+	for _, id := range ids {
+		assert.Equal(t, ids[0], id)
+	}
+	assert.Equal(t, int64(1), client.HLen(ctx, driversKey()).Val())
+	assert.Equal(t, int64(1), client.ZCard(ctx, Key).Val())
+}
@@ -2,16 +2,46 @@ package repository
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/s3f4/locationmatcher/internal/driverlocation/models"
 )
 
+// ErrIndexMissing is returned by CheckIndex when the geo index of driver
+// locations does not exist
+var ErrIndexMissing = fmt.Errorf("geo index of driver locations does not exist")
+
+// ErrIDConflict fails upserts whose id belongs to the driver location of
+// another driver
+var ErrIDConflict = fmt.Errorf("id belongs to another driver")
+
 // Repository ..
 type Repository interface {
-	UpsertBulk(context.Context, []*models.DriverLocation) error
+	// UpsertBulk upserts driver locations by driver id, or by id if the driver
+	// id is empty, and returns a result for every driver location.
+	UpsertBulk(context.Context, []*models.DriverLocation) ([]*models.UpsertResult, error)
 	Find(context.Context, *models.Query) ([]*models.DriverLocation, error)
 	Find1(context.Context, *models.Query) ([]*models.DriverLocation, error)
 	DropIfExists(context.Context) error
 	CreateIndex(context.Context, string, string) error
-	Migrate(context.Context)
+	Migrate(context.Context) error
+	// Ping checks the connection of the backend
+	Ping(context.Context) error
+	// CheckIndex checks the geo index of driver locations exists
+	CheckIndex(context.Context) error
+}
+
+// paginate applies the offset and limit of the query to driver locations
+// that are sorted by distance, it is used by backends that can not page results.
+func paginate(driverLocations []*models.DriverLocation, query *models.Query) []*models.DriverLocation {
+	if query.Offset >= int64(len(driverLocations)) {
+		return []*models.DriverLocation{}
+	}
+
+	driverLocations = driverLocations[query.Offset:]
+	if query.Limit > 0 && query.Limit < int64(len(driverLocations)) {
+		driverLocations = driverLocations[:query.Limit]
+	}
+
+	return driverLocations
 }
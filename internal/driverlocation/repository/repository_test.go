@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/s3f4/locationmatcher/internal/driverlocation/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// testUpsertByDriverID checks that upserts are keyed by driver id on an
+// empty repository, driver locations are dropped at the end.
+func testUpsertByDriverID(ctx context.Context, t *testing.T, repo Repository) {
+	driverLocations := []*models.DriverLocation{
+		{
+			DriverID: "driver-1",
+			Location: models.Location{
+				Type:        "Point",
+				Coordinates: []interface{}{28.97413088610361, 41.025651081666744},
+			},
+		},
+		{
+			DriverID: "driver-2",
+			Location: models.Location{
+				Type:        "Point",
+				Coordinates: []interface{}{28.979986854317975, 41.00858654897259},
+			},
+		},
+		{
+			DriverID: "driver-3",
+			Location: models.Location{
+				Type:        "Point",
+				Coordinates: []int{1, 2},
+			},
+		},
+	}
+
+	results, err := repo.UpsertBulk(ctx, driverLocations)
+	assert.Nil(t, err)
+	assert.Len(t, results, 3)
+	assert.Equal(t, models.UpsertInserted, results[0].Status)
+	assert.Equal(t, models.UpsertInserted, results[1].Status)
+	assert.Equal(t, models.UpsertFailed, results[2].Status)
+	assert.NotEmpty(t, results[2].Error)
+	assert.Equal(t, "driver-1", results[0].DriverID)
+
+	// the driver keeps its id although the request does not have one
+	moved := &models.DriverLocation{
+		DriverID: "driver-1",
+		Status:   models.StatusBusy,
+		Location: models.Location{
+			Type:        "Point",
+			Coordinates: []interface{}{28.9605116156308, 41.01189519061322},
+		},
+	}
+
+	results, err = repo.UpsertBulk(ctx, []*models.DriverLocation{moved})
+	assert.Nil(t, err)
+	assert.Equal(t, models.UpsertUpdated, results[0].Status)
+	assert.Equal(t, driverLocations[0].ID, results[0].ID)
+	assert.Equal(t, driverLocations[0].ID, moved.ID)
+
+	// a location update without a status keeps the stored status
+	located := &models.DriverLocation{
+		DriverID: "driver-1",
+		Location: moved.Location,
+	}
+	results, err = repo.UpsertBulk(ctx, []*models.DriverLocation{located})
+	assert.Nil(t, err)
+	assert.Equal(t, models.UpsertUpdated, results[0].Status)
+
+	locations, err := repo.Find1(ctx, &models.Query{
+		Location: models.Location{
+			Type:        "Point",
+			Coordinates: []interface{}{28.9605116156308, 41.01189519061322},
+		},
+		MaxDistance: 10000,
+	})
+	assert.Nil(t, err)
+	assert.Len(t, locations, 2)
+	assert.Equal(t, driverLocations[0].ID, locations[0].ID)
+	assert.Equal(t, "driver-1", locations[0].DriverID)
+	assert.Equal(t, models.StatusBusy, locations[0].Status)
+	// new drivers without a status are available
+	assert.Equal(t, models.StatusAvailable, locations[1].Status)
+
+	err = repo.DropIfExists(ctx)
+	assert.Nil(t, err)
+}
+
+// testIDConflict checks that a driver location can not take over the id of
+// another driver and that updates by id keep the driver.
+func testIDConflict(ctx context.Context, t *testing.T, repo Repository) {
+	driverLocation := &models.DriverLocation{
+		DriverID: "driver-1",
+		Status:   models.StatusBusy,
+		Location: models.Location{
+			Type:        "Point",
+			Coordinates: []interface{}{28.97413088610361, 41.025651081666744},
+		},
+	}
+
+	results, err := repo.UpsertBulk(ctx, []*models.DriverLocation{driverLocation})
+	assert.Nil(t, err)
+	assert.Equal(t, models.UpsertInserted, results[0].Status)
+
+	hijacked := &models.DriverLocation{
+		ID:       driverLocation.ID,
+		DriverID: "driver-9",
+		Location: models.Location{
+			Type:        "Point",
+			Coordinates: []interface{}{28.979986854317975, 41.00858654897259},
+		},
+	}
+	results, err = repo.UpsertBulk(ctx, []*models.DriverLocation{hijacked})
+	assert.Nil(t, err)
+	assert.Equal(t, models.UpsertFailed, results[0].Status)
+	assert.NotEmpty(t, results[0].Error)
+
+	// an update by id without a driver id keeps the driver
+	byID := &models.DriverLocation{
+		ID: driverLocation.ID,
+		Location: models.Location{
+			Type:        "Point",
+			Coordinates: []interface{}{28.9605116156308, 41.01189519061322},
+		},
+	}
+	results, err = repo.UpsertBulk(ctx, []*models.DriverLocation{byID})
+	assert.Nil(t, err)
+	assert.Equal(t, models.UpsertUpdated, results[0].Status)
+
+	locations, err := repo.Find1(ctx, &models.Query{
+		Location: models.Location{
+			Type:        "Point",
+			Coordinates: []interface{}{28.9605116156308, 41.01189519061322},
+		},
+		MaxDistance: 10000,
+	})
+	assert.Nil(t, err)
+	assert.Len(t, locations, 1)
+	assert.Equal(t, driverLocation.ID, locations[0].ID)
+	assert.Equal(t, "driver-1", locations[0].DriverID)
+	assert.Equal(t, models.StatusBusy, locations[0].Status)
+
+	err = repo.DropIfExists(ctx)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+
+	"github.com/s3f4/locationmatcher/internal/driverlocation/models"
+)
+
+const (
+	// sourcePath is the csv file that holds the initial driver locations
+	sourcePath = "./source/coordinates.csv"
+	// migrateBatchSize is the number of driver locations written at once by
+	// backends that limit the request size
+	migrateBatchSize = 5000
+)
+
+// readSource reads driver locations from the csv file at the given path,
+// the first row is the header and the columns are latitude, longitude.
+func readSource(path string) ([]*models.DriverLocation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.Comma = ','
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	driverLocations := []*models.DriverLocation{}
+	for i, row := range rows {
+		if i == 0 {
+			continue
+		}
+
+		driverLocation := &models.DriverLocation{}
+		latitude, err := strconv.ParseFloat(row[0], 64)
+		if err != nil {
+			return nil, err
+		}
+
+		longitude, err := strconv.ParseFloat(row[1], 64)
+		if err != nil {
+			return nil, err
+		}
+
+		driverLocation.Location.Type = "Point"
+		driverLocation.Location.Coordinates = []float64{longitude, latitude}
+		driverLocations = append(driverLocations, driverLocation)
+	}
+
+	return driverLocations, nil
+}
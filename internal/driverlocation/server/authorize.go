@@ -0,0 +1,34 @@
+package server
+
+import (
+	"context"
+
+	"github.com/s3f4/locationmatcher/internal/driverlocation/models"
+	"github.com/s3f4/locationmatcher/pkg/auth"
+)
+
+// canUpsert reports whether the claims of ctx may upsert driverLocations,
+// admins may upsert any driver location and drivers only their own. Drivers
+// can not set ids, so they can not take over the id of another driver.
+func canUpsert(ctx context.Context, driverLocations []*models.DriverLocation) bool {
+	claims, ok := auth.ClaimsFromContext(ctx)
+	if !ok {
+		return false
+	}
+
+	if auth.HasScope(claims, auth.ScopeAdmin) {
+		return true
+	}
+
+	driverID := auth.DriverID(claims)
+	if driverID == "" || !auth.HasScope(claims, auth.ScopeDriver) {
+		return false
+	}
+
+	for _, driverLocation := range driverLocations {
+		if driverLocation.DriverID != driverID || !driverLocation.ID.IsZero() {
+			return false
+		}
+	}
+	return true
+}
@@ -2,13 +2,216 @@ package server
 
 import (
 	"context"
+	"net"
+	"os"
 
+	"github.com/s3f4/locationmatcher/internal/driverlocation/models"
+	"github.com/s3f4/locationmatcher/internal/driverlocation/pb"
 	"github.com/s3f4/locationmatcher/internal/driverlocation/repository"
+	"github.com/s3f4/locationmatcher/internal/driverlocation/server/middlewares"
 	"github.com/s3f4/locationmatcher/pkg/log"
+	"github.com/s3f4/locationmatcher/pkg/shutdown"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-type grpcServer struct{}
+type grpcServer struct {
+	pb.UnimplementedDriverLocationServiceServer
+	repository repository.Repository
+}
+
+// Start starts grpc server
+func (g *grpcServer) Start(ctx context.Context, repository repository.Repository) {
+	service := os.Getenv("SERVICE")
+	port := os.Getenv("PORT")
+
+	g.repository = repository
+
+	listener, err := net.Listen("tcp", port)
+	if err != nil {
+		log.Fatalf("%s gRPC server listen err: %s\n", service, err)
+	}
+
+	server := grpc.NewServer(grpc.UnaryInterceptor(middlewares.AuthInterceptor))
+	pb.RegisterDriverLocationServiceServer(server, g)
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(pb.DriverLocationService_ServiceDesc.ServiceName, grpc_health_v1.HealthCheckResponse_SERVING)
+	grpc_health_v1.RegisterHealthServer(server, healthServer)
+
+	// service discovery for grpcurl and similar tools
+	reflection.Register(server)
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != grpc.ErrServerStopped {
+			log.Fatalf("%s gRPC server serve err: %s\n", service, err)
+		}
+	}()
+
+	log.Infof("%s gRPC server started on port %s...\n", service, port)
+	<-ctx.Done()
+	log.Infof("%s gRPC server is draining...\n", service)
+	shutdown.GRPC(server, healthServer)
+	log.Infof("%s gRPC server stopped. \n", service)
+}
+
+// UpsertBulk creates or updates driver locations by driver id
+func (g *grpcServer) UpsertBulk(ctx context.Context, req *pb.UpsertBulkRequest) (*pb.UpsertBulkResponse, error) {
+	if len(req.GetDriverLocations()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "provide valid driver locations")
+	}
+
+	driverLocations := make([]*models.DriverLocation, 0, len(req.GetDriverLocations()))
+	for _, dl := range req.GetDriverLocations() {
+		driverLocation, err := driverLocationFromProto(dl)
+		if err != nil {
+			log.FromContext(ctx).Error(err)
+			return nil, status.Error(codes.InvalidArgument, "provide valid driver locations")
+		}
+
+		if err := driverLocation.Validate(); err != nil {
+			log.FromContext(ctx).Error(err)
+			return nil, status.Error(codes.InvalidArgument, "provide valid driver locations")
+		}
+		driverLocations = append(driverLocations, driverLocation)
+	}
+
+	if !canUpsert(ctx, driverLocations) {
+		return nil, status.Error(codes.PermissionDenied, "Forbidden")
+	}
+
+	results, err := g.repository.UpsertBulk(ctx, driverLocations)
+	if err != nil {
+		log.FromContext(ctx).Error(err)
+		return nil, status.Error(codes.Internal, "Internal Server Error")
+	}
+
+	// ids, statuses and update times are set by the repository
+	response := &pb.UpsertBulkResponse{
+		DriverLocations: make([]*pb.DriverLocation, 0, len(driverLocations)),
+		Results:         make([]*pb.UpsertResult, 0, len(results)),
+	}
+
+	for _, result := range results {
+		response.Results = append(response.Results, &pb.UpsertResult{
+			Id:       result.ID.Hex(),
+			DriverId: result.DriverID,
+			Status:   string(result.Status),
+			Error:    result.Error,
+		})
+	}
+
+	for _, driverLocation := range driverLocations {
+		dl, err := driverLocationToProto(driverLocation)
+		if err != nil {
+			log.FromContext(ctx).Error(err)
+			return nil, status.Error(codes.Internal, "Internal Server Error")
+		}
+		response.DriverLocations = append(response.DriverLocations, dl)
+	}
+
+	return response, nil
+}
+
+// FindNearest returns nearest locations within the given query parameters
+func (g *grpcServer) FindNearest(ctx context.Context, req *pb.FindNearestRequest) (*pb.FindNearestResponse, error) {
+	query := queryFromProto(req)
+	if err := query.Validate(); err != nil {
+		log.FromContext(ctx).Error(err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	locations, err := g.repository.Find1(ctx, query)
+	if err != nil {
+		log.FromContext(ctx).Error(err)
+		return nil, status.Error(codes.Internal, "Internal Server Error")
+	}
+
+	if len(locations) == 0 {
+		return nil, status.Error(codes.NotFound, "Not Found")
+	}
+
+	response := &pb.FindNearestResponse{
+		Total:     int32(len(locations)),
+		Locations: make([]*pb.DriverLocation, 0, len(locations)),
+	}
+
+	for _, location := range locations {
+		dl, err := driverLocationToProto(location)
+		if err != nil {
+			log.FromContext(ctx).Error(err)
+			return nil, status.Error(codes.Internal, "Internal Server Error")
+		}
+		response.Locations = append(response.Locations, dl)
+	}
+
+	return response, nil
+}
+
+// locationFromProto converts coordinates to []interface{} which is
+// what the models expect after json decoding.
+func locationFromProto(location *pb.Location) models.Location {
+	coordinates := make([]interface{}, 0, len(location.GetCoordinates()))
+	for _, coordinate := range location.GetCoordinates() {
+		coordinates = append(coordinates, coordinate)
+	}
+
+	return models.Location{
+		Type:        location.GetType(),
+		Coordinates: coordinates,
+	}
+}
+
+func queryFromProto(req *pb.FindNearestRequest) *models.Query {
+	return &models.Query{
+		Location:    locationFromProto(req.GetLocation()),
+		MinDistance: req.GetMinDistance(),
+		MaxDistance: req.GetMaxDistance(),
+		Limit:       req.GetLimit(),
+		Offset:      req.GetOffset(),
+		Status:      models.DriverStatus(req.GetStatus()),
+	}
+}
+
+func driverLocationFromProto(dl *pb.DriverLocation) (*models.DriverLocation, error) {
+	driverLocation := &models.DriverLocation{
+		DriverID: dl.GetDriverId(),
+		Status:   models.DriverStatus(dl.GetStatus()),
+		Location: locationFromProto(dl.GetLocation()),
+	}
+
+	if dl.GetId() != "" {
+		id, err := primitive.ObjectIDFromHex(dl.GetId())
+		if err != nil {
+			return nil, err
+		}
+		driverLocation.ID = id
+	}
+
+	return driverLocation, nil
+}
+
+func driverLocationToProto(driverLocation *models.DriverLocation) (*pb.DriverLocation, error) {
+	coordinates, err := driverLocation.Coordinates()
+	if err != nil {
+		return nil, err
+	}
 
-func (*grpcServer) Start(ctx context.Context, repository repository.Repository) {
-	log.Fatal("not implemented")
+	return &pb.DriverLocation{
+		Id: driverLocation.ID.Hex(),
+		Location: &pb.Location{
+			Type:        driverLocation.Location.Type,
+			Coordinates: coordinates,
+		},
+		Distance:  driverLocation.Distance,
+		DriverId:  driverLocation.DriverID,
+		Status:    string(driverLocation.Status),
+		UpdatedAt: timestamppb.New(driverLocation.UpdatedAt),
+	}, nil
 }
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/s3f4/locationmatcher/internal/driverlocation/mocks"
+	"github.com/s3f4/locationmatcher/internal/driverlocation/models"
+	"github.com/s3f4/locationmatcher/internal/driverlocation/pb"
+	"github.com/s3f4/locationmatcher/internal/driverlocation/server/middlewares"
+	"github.com/s3f4/locationmatcher/pkg/auth"
+	"github.com/s3f4/locationmatcher/pkg/signature"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testKey signs the requests of the test clients and testSecret their tokens
+var (
+	testKey    = signature.Key{ID: "test", Secret: []byte("secret")}
+	testSecret = "token-secret"
+)
+
+func newGRPCTestClient(t *testing.T, repository *mocks.Repository) pb.DriverLocationServiceClient {
+	return newSignedGRPCTestClient(t, repository, testKey)
+}
+
+// newSignedGRPCTestClient returns a client that signs its requests with key
+func newSignedGRPCTestClient(t *testing.T, repository *mocks.Repository, key signature.Key) pb.DriverLocationServiceClient {
+	middlewares.SetVerifier(signature.NewVerifier([]signature.Key{testKey}))
+	tokenVerifier, err := auth.NewVerifier(auth.VerifierConfig{HMACSecrets: []string{testSecret}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	middlewares.SetTokenVerifier(tokenVerifier)
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(grpc.UnaryInterceptor(middlewares.AuthInterceptor))
+	pb.RegisterDriverLocationServiceServer(server, &grpcServer{repository: repository})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(signature.UnaryClientInterceptor(signature.NewSigner(key))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewDriverLocationServiceClient(conn)
+}
+
+// tokenContext returns a context that sends a token with claims
+func tokenContext(t *testing.T, claims jwt.MapClaims) context.Context {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func Test_GRPC_Unauthenticated(t *testing.T) {
+	client := newSignedGRPCTestClient(t, new(mocks.Repository), signature.Key{ID: "unknown", Secret: []byte("secret")})
+
+	_, err := client.FindNearest(context.Background(), &pb.FindNearestRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func Test_GRPC_FindNearest(t *testing.T) {
+	query := &models.Query{
+		Location: models.Location{
+			Type:        "Point",
+			Coordinates: []interface{}{41.90513187, 29.15188821},
+		},
+		MinDistance: 55,
+		MaxDistance: 10000,
+	}
+
+	tests := []struct {
+		name      string
+		req       *pb.FindNearestRequest
+		locations []*models.DriverLocation
+		err       error
+		code      codes.Code
+	}{
+		{
+			name: "invalid_type",
+			req: &pb.FindNearestRequest{
+				Location:    &pb.Location{Type: "Poin", Coordinates: []float64{41.90513187, 29.15188821}},
+				MinDistance: 55,
+				MaxDistance: 10000,
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "repository_error",
+			req: &pb.FindNearestRequest{
+				Location:    &pb.Location{Type: "Point", Coordinates: []float64{41.90513187, 29.15188821}},
+				MinDistance: 55,
+				MaxDistance: 10000,
+			},
+			err:  errors.New("error"),
+			code: codes.Internal,
+		},
+		{
+			name: "not_found",
+			req: &pb.FindNearestRequest{
+				Location:    &pb.Location{Type: "Point", Coordinates: []float64{41.90513187, 29.15188821}},
+				MinDistance: 55,
+				MaxDistance: 10000,
+			},
+			locations: []*models.DriverLocation{},
+			code:      codes.NotFound,
+		},
+		{
+			name: "success",
+			req: &pb.FindNearestRequest{
+				Location:    &pb.Location{Type: "Point", Coordinates: []float64{41.90513187, 29.15188821}},
+				MinDistance: 55,
+				MaxDistance: 10000,
+			},
+			locations: []*models.DriverLocation{
+				{
+					Location: models.Location{
+						Type:        "Point",
+						Coordinates: []float64{41.9, 29.1},
+					},
+					Distance: 6.3,
+				},
+			},
+			code: codes.OK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := new(mocks.Repository)
+			repository.On("Find1", mock.Anything, query).Return(tt.locations, tt.err)
+			client := newGRPCTestClient(t, repository)
+
+			res, err := client.FindNearest(context.Background(), tt.req)
+			assert.Equal(t, tt.code, status.Code(err))
+			if tt.code == codes.OK {
+				assert.Equal(t, int32(1), res.GetTotal())
+				assert.Equal(t, []float64{41.9, 29.1}, res.GetLocations()[0].GetLocation().GetCoordinates())
+				assert.Equal(t, 6.3, res.GetLocations()[0].GetDistance())
+			}
+		})
+	}
+}
+
+func Test_GRPC_UpsertBulk(t *testing.T) {
+	valid := &pb.DriverLocation{
+		Id:       "6219f72c61d60d9a30ff2072",
+		Location: &pb.Location{Type: "Point", Coordinates: []float64{40.94001079, 29.00077262}},
+	}
+
+	tests := []struct {
+		name string
+		req  *pb.UpsertBulkRequest
+		err  error
+		code codes.Code
+	}{
+		{"empty", &pb.UpsertBulkRequest{}, nil, codes.InvalidArgument},
+		{"invalid_id", &pb.UpsertBulkRequest{DriverLocations: []*pb.DriverLocation{{
+			Id:       "invalid",
+			Location: valid.Location,
+		}}}, nil, codes.InvalidArgument},
+		{"invalid_longitude", &pb.UpsertBulkRequest{DriverLocations: []*pb.DriverLocation{{
+			Location: &pb.Location{Type: "Point", Coordinates: []float64{-190.94001079, 29.00077262}},
+		}}}, nil, codes.InvalidArgument},
+		{"repository_error", &pb.UpsertBulkRequest{DriverLocations: []*pb.DriverLocation{valid}}, errors.New("error"), codes.Internal},
+		{"success", &pb.UpsertBulkRequest{DriverLocations: []*pb.DriverLocation{valid}}, nil, codes.OK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := new(mocks.Repository)
+			var results []*models.UpsertResult
+			if tt.err == nil {
+				id, _ := primitive.ObjectIDFromHex(valid.GetId())
+				results = []*models.UpsertResult{{ID: id, Status: models.UpsertUpdated}}
+			}
+			repository.On("UpsertBulk", mock.Anything, mock.Anything).Return(results, tt.err)
+			client := newGRPCTestClient(t, repository)
+
+			res, err := client.UpsertBulk(tokenContext(t, jwt.MapClaims{"scope": auth.ScopeAdmin}), tt.req)
+			assert.Equal(t, tt.code, status.Code(err))
+			if tt.code == codes.OK {
+				assert.Len(t, res.GetDriverLocations(), 1)
+				assert.Equal(t, valid.GetId(), res.GetDriverLocations()[0].GetId())
+				assert.Len(t, res.GetResults(), 1)
+				assert.Equal(t, string(models.UpsertUpdated), res.GetResults()[0].GetStatus())
+			}
+		})
+	}
+}
+
+func Test_GRPC_Scopes(t *testing.T) {
+	own := &pb.UpsertBulkRequest{DriverLocations: []*pb.DriverLocation{{
+		DriverId: "driver-1",
+		Location: &pb.Location{Type: "Point", Coordinates: []float64{40.94001079, 29.00077262}},
+	}}}
+	other := &pb.UpsertBulkRequest{DriverLocations: []*pb.DriverLocation{{
+		DriverId: "driver-2",
+		Location: &pb.Location{Type: "Point", Coordinates: []float64{40.94001079, 29.00077262}},
+	}}}
+	// the id of another driver can not be taken over
+	hijack := &pb.UpsertBulkRequest{DriverLocations: []*pb.DriverLocation{{
+		Id:       "6219f72c61d60d9a30ff2072",
+		DriverId: "driver-1",
+		Location: &pb.Location{Type: "Point", Coordinates: []float64{40.94001079, 29.00077262}},
+	}}}
+	driver := jwt.MapClaims{"scope": auth.ScopeDriver, "driver_id": "driver-1"}
+
+	repository := new(mocks.Repository)
+	repository.On("UpsertBulk", mock.Anything, mock.Anything).Return([]*models.UpsertResult{{DriverID: "driver-1", Status: models.UpsertInserted}}, nil)
+	client := newGRPCTestClient(t, repository)
+
+	_, err := client.UpsertBulk(tokenContext(t, driver), own)
+	assert.Nil(t, err)
+
+	_, err = client.UpsertBulk(tokenContext(t, driver), other)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = client.UpsertBulk(tokenContext(t, driver), hijack)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// signed service requests may only find nearest drivers
+	_, err = client.UpsertBulk(context.Background(), own)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = client.FindNearest(tokenContext(t, driver), &pb.FindNearestRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = client.FindNearest(metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer invalid"), &pb.FindNearestRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	repository.AssertNumberOfCalls(t, "UpsertBulk", 1)
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"context"
+	"os"
+
+	"github.com/s3f4/locationmatcher/internal/driverlocation/repository"
+	"github.com/s3f4/locationmatcher/pkg/health"
+	"github.com/s3f4/locationmatcher/pkg/shutdown"
+)
+
+// readyChecks checks the server is not draining, the repository backend is
+// reachable and the geo index of driver locations exists
+func readyChecks(readiness *shutdown.Readiness, repository repository.Repository) health.Checks {
+	backend := os.Getenv("REPOSITORY")
+
+	return health.Checks{
+		"server": health.Serving(readiness),
+		"repository": func(ctx context.Context) health.Component {
+			component := health.FromError(repository.Ping(ctx))
+			component.Details = map[string]string{"backend": backend}
+			return component
+		},
+		"index": func(ctx context.Context) health.Component {
+			return health.FromError(repository.CheckIndex(ctx))
+		},
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -12,12 +13,32 @@ import (
 	"github.com/s3f4/locationmatcher/internal/driverlocation/models"
 	"github.com/s3f4/locationmatcher/internal/driverlocation/repository"
 	"github.com/s3f4/locationmatcher/internal/driverlocation/server/middlewares"
+	"github.com/s3f4/locationmatcher/pkg/accesslog"
 	"github.com/s3f4/locationmatcher/pkg/apihelper"
+	"github.com/s3f4/locationmatcher/pkg/auth"
+	"github.com/s3f4/locationmatcher/pkg/health"
 	"github.com/s3f4/locationmatcher/pkg/log"
+	"github.com/s3f4/locationmatcher/pkg/metrics"
+	"github.com/s3f4/locationmatcher/pkg/requestid"
+	"github.com/s3f4/locationmatcher/pkg/shutdown"
+	"github.com/s3f4/locationmatcher/pkg/tracing"
 )
 
+// migrateTimeout bounds a migration, it runs after its request is answered
+const migrateTimeout = 10 * time.Minute
+
 type httpServer struct {
 	repository repository.Repository
+	readiness  shutdown.Readiness
+
+	// ctx is the context of Start, migrations are canceled when it is done
+	// and Start waits for them before it returns.
+	ctx        context.Context
+	migrations sync.WaitGroup
+
+	// migrating is set while a migration runs
+	mu        sync.Mutex
+	migrating bool
 }
 
 // Start starts http server
@@ -26,13 +47,29 @@ func (h *httpServer) Start(ctx context.Context, repository repository.Repository
 	port := os.Getenv("PORT")
 
 	h.repository = repository
+	h.ctx = ctx
 
 	var router *chi.Mux = chi.NewRouter()
 
+	router.Use(requestid.Middleware, tracing.Middleware, metrics.Middleware, accesslog.Middleware)
+
+	// probes of the orchestrator and scrapes of prometheus are not authenticated
+	router.Handle("/metrics", metrics.Handler())
+	router.Get("/healthz", health.Live)
+	router.Get("/readyz", health.Ready(readyChecks(&h.readiness, h.repository)))
+
 	router.Route("/api/v1/driver_locations", func(router chi.Router) {
 		router.Use(middlewares.AuthCtx)
-		router.Post("/", h.UpsertBulk)
-		router.Post("/find_nearest", h.Find)
+		router.With(auth.RequireScope(auth.ScopeDriver, auth.ScopeAdmin)).Post("/", h.UpsertBulk)
+		router.With(auth.RequireScope(auth.ScopeDispatch)).Post("/find_nearest", h.Find)
+		router.With(auth.RequireScope(auth.ScopeAdmin)).Post("/migrate", h.Migrate)
+	})
+
+	// the log level is changed at runtime by admins
+	router.Route("/admin", func(router chi.Router) {
+		router.Use(middlewares.AuthCtx, auth.RequireScope(auth.ScopeAdmin))
+		router.Method(http.MethodGet, "/log/level", log.LevelHandler())
+		router.Method(http.MethodPut, "/log/level", log.LevelHandler())
 	})
 
 	// documentation for developers
@@ -65,22 +102,29 @@ func (h *httpServer) Start(ctx context.Context, repository repository.Repository
 
 	log.Infof("%s HTTP server started on port %s...\n", service, port)
 	<-ctx.Done()
+	log.Infof("%s HTTP server is draining...\n", service)
+	if err := shutdown.HTTP(server, &h.readiness); err != nil {
+		log.Errorf("%s HTTP server shutdown err: %s\n", service, err)
+	}
+	// the repository is closed after Start returns
+	h.migrations.Wait()
 	log.Infof("%s HTTP server stopped. \n", service)
 }
 
 // swagger:route POST / UpsertBulk
-// Create or update driver locations
+// Create or update driver locations by driver id, or by id if the driver id is empty
 //
 // security:
 // - apiKey: []
 // responses:
 //  401: ApiError
-//  200: Response
+//  403: ApiError
+//  200: UpsertResults
 func (h *httpServer) UpsertBulk(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	var driverLocations []*models.DriverLocation
 	if err := json.NewDecoder(r.Body).Decode(&driverLocations); err != nil {
-		log.Error(err)
+		log.FromContext(ctx).Error(err)
 		apihelper.Send400(w)
 		return
 	}
@@ -96,20 +140,26 @@ func (h *httpServer) UpsertBulk(w http.ResponseWriter, r *http.Request) {
 	} else {
 		for _, driverLocation := range driverLocations {
 			if err := driverLocation.Validate(); err != nil {
-				log.Error(err)
+				log.FromContext(ctx).Error(err)
 				apihelper.SendResponse(w, http.StatusBadRequest, notValidResponse)
 				return
 			}
 		}
 	}
 
-	if err := h.repository.UpsertBulk(ctx, driverLocations); err != nil {
-		log.Error(err)
+	if !canUpsert(ctx, driverLocations) {
+		apihelper.Send403(w)
+		return
+	}
+
+	results, err := h.repository.UpsertBulk(ctx, driverLocations)
+	if err != nil {
+		log.FromContext(ctx).Error(err)
 		apihelper.Send500(w)
 		return
 	}
 
-	apihelper.SendResponse(w, http.StatusOK, driverLocations)
+	apihelper.SendResponse(w, http.StatusOK, results)
 }
 
 // swagger:route POST /find_nearest Find
@@ -119,12 +169,13 @@ func (h *httpServer) UpsertBulk(w http.ResponseWriter, r *http.Request) {
 // - apiKey: []
 // responses:
 //  401: ApiError
+//  403: ApiError
 //  200: DriverLocations
 func (h *httpServer) Find(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	var query models.Query
 	if err := apihelper.ParseAndValidate(r, &query); err != nil {
-		log.Error(err)
+		log.FromContext(ctx).Error(err)
 		apihelper.SendResponse(w, err.Code, apihelper.Response{
 			Code: err.Code,
 			Msg:  err.Msg,
@@ -134,7 +185,7 @@ func (h *httpServer) Find(w http.ResponseWriter, r *http.Request) {
 
 	locations, err := h.repository.Find1(ctx, &query)
 	if err != nil {
-		log.Error(err)
+		log.FromContext(ctx).Error(err)
 		apihelper.Send500(w)
 		return
 	}
@@ -154,3 +205,55 @@ func (h *httpServer) Find(w http.ResponseWriter, r *http.Request) {
 		},
 	)
 }
+
+// swagger:route POST /migrate Migrate
+// Recreates the driver locations from the source file in the background
+//
+// security:
+// - apiKey: []
+// responses:
+//  401: ApiError
+//  403: ApiError
+//  409: ApiError
+//  202: ApiError
+func (h *httpServer) Migrate(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	if h.migrating {
+		h.mu.Unlock()
+		apihelper.Send409(w)
+		return
+	}
+	h.migrating = true
+	h.mu.Unlock()
+
+	// the migration outlives the request and its write timeout, it is
+	// canceled when the server shuts down
+	parent := h.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	logger := log.FromContext(r.Context())
+	h.migrations.Add(1)
+	go func() {
+		defer h.migrations.Done()
+		defer func() {
+			h.mu.Lock()
+			h.migrating = false
+			h.mu.Unlock()
+		}()
+
+		ctx, cancel := context.WithTimeout(parent, migrateTimeout)
+		defer cancel()
+
+		if err := h.repository.Migrate(ctx); err != nil {
+			logger.Error(err)
+			return
+		}
+		logger.Info("driver locations are migrated")
+	}()
+
+	apihelper.SendResponse(w, http.StatusAccepted, apihelper.Response{
+		Code: http.StatusAccepted,
+		Msg:  "driver locations are being migrated",
+	})
+}
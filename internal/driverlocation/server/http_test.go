@@ -9,9 +9,14 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/golang-jwt/jwt"
 	"github.com/s3f4/locationmatcher/internal/driverlocation/mocks"
 	"github.com/s3f4/locationmatcher/internal/driverlocation/models"
+	"github.com/s3f4/locationmatcher/internal/driverlocation/repository"
+	"github.com/s3f4/locationmatcher/pkg/auth"
+	"github.com/s3f4/locationmatcher/pkg/health"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -44,7 +49,7 @@ var FindDataNotFound = []testParams{
 }
 
 var FindDataSuccess = []testParams{
-	{"find_nearest_success_success", http.MethodPost, "/api/v1/driver_location/find_nearest", `{"location": {"type": "Point","coordinates": [41.90513187,29.15188821]},"minDistance": 55,"maxDistance": 10000}`, 200, `{"code":200,"data":{"total":1,"locations":[{"_id":"000000000000000000000000","location":{"type":"","coordinates":null},"distance":0}]}}`},
+	{"find_nearest_success_success", http.MethodPost, "/api/v1/driver_location/find_nearest", `{"location": {"type": "Point","coordinates": [41.90513187,29.15188821]},"minDistance": 55,"maxDistance": 10000}`, 200, `{"code":200,"data":{"total":1,"locations":[{"_id":"000000000000000000000000","updated_at":"0001-01-01T00:00:00Z","location":{"type":"","coordinates":null},"distance":0}]}}`},
 }
 
 var UpsertBulkParams = []testParams{
@@ -52,24 +57,32 @@ var UpsertBulkParams = []testParams{
 	{"upsertbulk_parse_error", http.MethodPost, "/api/v1/driver_location", `[]`, 400, `{"code":400,"msg":"provide valid driver locations"}`},
 	{"upsertbulk_invalid_latitude", http.MethodPost, "/api/v1/driver_location", `[{"_id":"6219f72c61d60d9a30ff2072","location":{"type":"Point","coordinates":[-190.94001079,29.00077262]}}]`, 400, `{"code":400,"msg":"provide valid driver locations"}`},
 	{"upsertbulk_invalid_longitude", http.MethodPost, "/api/v1/driver_location", `[{"_id":"6219f72c61d60d9a30ff2072","location":{"type":"Point","coordinates":[40.94001079,191.00077262]}}]`, 400, `{"code":400,"msg":"provide valid driver locations"}`},
+	{"upsertbulk_invalid_status", http.MethodPost, "/api/v1/driver_location", `[{"_id":"6219f72c61d60d9a30ff2072","status":"sleeping","location":{"type":"Point","coordinates":[40.94001079,29.00077262]}}]`, 400, `{"code":400,"msg":"provide valid driver locations"}`},
 	// {"driver_location_valid_request", http.MethodPost, "/api/v1/driver_location", `[{"_id":"6219f72c61d60d9a30ff2072","location":{"type":"Point","coordinates":[40.94001079,29.00077262]}}]`, 200, `[{"_id":"6219f72c61d60d9a30ff2072","location":{"type":"Point","coordinates":[40.94001079,29.00077262]}}]`},
 }
 
 var UpsertBulkValues = []testParams{
-	{"driver_location_valid_request", http.MethodPost, "/api/v1/driver_location", `[{"_id":"6219f72c61d60d9a30ff2072","location":{"type":"Point","coordinates":[40.94001079,29.00077262]}}]`, 200, `[{"_id":"6219f72c61d60d9a30ff2072","location":{"type":"Point","coordinates":[40.94001079,29.00077262]},"distance":0}]`},
+	{"driver_location_valid_request", http.MethodPost, "/api/v1/driver_location", `[{"_id":"6219f72c61d60d9a30ff2072","location":{"type":"Point","coordinates":[40.94001079,29.00077262]}}]`, 200, `[{"_id":"6219f72c61d60d9a30ff2072","status":"updated"}]`},
 }
 
 var UpsertBulkErr = []testParams{
 	{"driver_location_valid_request_error", http.MethodPost, "/api/v1/driver_location", `[{"_id":"6219f72c61d60d9a30ff2072","location":{"type":"Point","coordinates":[40.94001079,29.00077262]}}]`, 500, `{"code":500,"msg":"Internal Server Error"}`},
 }
 
+// withClaims returns req with claims in its context, as AuthCtx does
+func withClaims(req *http.Request, claims jwt.MapClaims) *http.Request {
+	return req.WithContext(auth.WithClaims(req.Context(), claims))
+}
+
+var adminClaims = jwt.MapClaims{"scope": auth.ScopeAdmin}
+
 func Test_Find_Param(t *testing.T) {
 	driverLocationRepository := new(mocks.Repository)
-	driverLocationRepository.On("Find", context.TODO(), mock.Anything).Return(nil, errors.New("error"))
+	driverLocationRepository.On("Find", mock.Anything, mock.Anything).Return(nil, errors.New("error"))
 
 	for _, data := range FindDataParam {
 		t.Run(data.name, func(t *testing.T) {
-			driverLocationHandler := &httpServer{driverLocationRepository}
+			driverLocationHandler := &httpServer{repository: driverLocationRepository}
 			w := httptest.NewRecorder()
 			req := httptest.NewRequest(data.method, data.url, strings.NewReader(data.body))
 			driverLocationHandler.Find(w, req)
@@ -88,7 +101,7 @@ func Test_Find_Param(t *testing.T) {
 
 func Test_Find(t *testing.T) {
 	driverLocationRepository := new(mocks.Repository)
-	driverLocationRepository.On("Find1", context.TODO(), &models.Query{
+	driverLocationRepository.On("Find1", mock.Anything, &models.Query{
 		Location: models.Location{
 			Type:        "Point",
 			Coordinates: []interface{}{41.90513187, 29.15188821},
@@ -99,7 +112,7 @@ func Test_Find(t *testing.T) {
 
 	for _, data := range FindData {
 		t.Run(data.name, func(t *testing.T) {
-			driverLocationHandler := &httpServer{driverLocationRepository}
+			driverLocationHandler := &httpServer{repository: driverLocationRepository}
 			w := httptest.NewRecorder()
 			req := httptest.NewRequest(data.method, data.url, strings.NewReader(data.body))
 			driverLocationHandler.Find(w, req)
@@ -119,7 +132,7 @@ func Test_Find(t *testing.T) {
 
 func Test_Find_NotFound(t *testing.T) {
 	driverLocationRepository := new(mocks.Repository)
-	driverLocationRepository.On("Find1", context.TODO(), &models.Query{
+	driverLocationRepository.On("Find1", mock.Anything, &models.Query{
 		Location: models.Location{
 			Type:        "Point",
 			Coordinates: []interface{}{41.90513187, 29.15188821},
@@ -130,7 +143,7 @@ func Test_Find_NotFound(t *testing.T) {
 
 	for _, data := range FindDataNotFound {
 		t.Run(data.name, func(t *testing.T) {
-			driverLocationHandler := &httpServer{driverLocationRepository}
+			driverLocationHandler := &httpServer{repository: driverLocationRepository}
 			w := httptest.NewRecorder()
 			req := httptest.NewRequest(data.method, data.url, strings.NewReader(data.body))
 			driverLocationHandler.Find(w, req)
@@ -149,7 +162,7 @@ func Test_Find_NotFound(t *testing.T) {
 
 func Test_Find_Success(t *testing.T) {
 	driverLocationRepository := new(mocks.Repository)
-	driverLocationRepository.On("Find1", context.TODO(), &models.Query{
+	driverLocationRepository.On("Find1", mock.Anything, &models.Query{
 		Location: models.Location{
 			Type:        "Point",
 			Coordinates: []interface{}{41.90513187, 29.15188821},
@@ -160,7 +173,7 @@ func Test_Find_Success(t *testing.T) {
 
 	for _, data := range FindDataSuccess {
 		t.Run(data.name, func(t *testing.T) {
-			driverLocationHandler := &httpServer{driverLocationRepository}
+			driverLocationHandler := &httpServer{repository: driverLocationRepository}
 			w := httptest.NewRecorder()
 			req := httptest.NewRequest(data.method, data.url, strings.NewReader(data.body))
 			driverLocationHandler.Find(w, req)
@@ -180,12 +193,12 @@ func Test_Find_Success(t *testing.T) {
 
 func Test_UpsertBulk_Params(t *testing.T) {
 	driverLocationRepository := new(mocks.Repository)
-	driverLocationRepository.On("UpsertBulk", context.TODO(), []*models.DriverLocation{}).Return(nil)
+	driverLocationRepository.On("UpsertBulk", mock.Anything, []*models.DriverLocation{}).Return(nil, nil)
 
 	for _, data := range UpsertBulkParams {
-		driverLocationHandler := &httpServer{driverLocationRepository}
+		driverLocationHandler := &httpServer{repository: driverLocationRepository}
 		w := httptest.NewRecorder()
-		req := httptest.NewRequest(data.method, data.url, strings.NewReader(data.body))
+		req := withClaims(httptest.NewRequest(data.method, data.url, strings.NewReader(data.body)), adminClaims)
 		driverLocationHandler.UpsertBulk(w, req)
 
 		res := w.Result()
@@ -202,7 +215,7 @@ func Test_UpsertBulk_Params(t *testing.T) {
 func Test_UpsertBulk_Valid(t *testing.T) {
 	driverLocationRepository := new(mocks.Repository)
 	id, _ := primitive.ObjectIDFromHex("6219f72c61d60d9a30ff2072")
-	driverLocationRepository.On("UpsertBulk", context.TODO(), []*models.DriverLocation{
+	driverLocationRepository.On("UpsertBulk", mock.Anything, []*models.DriverLocation{
 		{
 			ID: id,
 			Location: models.Location{
@@ -210,12 +223,12 @@ func Test_UpsertBulk_Valid(t *testing.T) {
 				Coordinates: []interface{}{40.94001079, 29.00077262},
 			},
 		},
-	}).Return(nil)
+	}).Return([]*models.UpsertResult{{ID: id, Status: models.UpsertUpdated}}, nil)
 
 	for _, data := range UpsertBulkValues {
-		driverLocationHandler := &httpServer{driverLocationRepository}
+		driverLocationHandler := &httpServer{repository: driverLocationRepository}
 		w := httptest.NewRecorder()
-		req := httptest.NewRequest(data.method, data.url, strings.NewReader(data.body))
+		req := withClaims(httptest.NewRequest(data.method, data.url, strings.NewReader(data.body)), adminClaims)
 		driverLocationHandler.UpsertBulk(w, req)
 
 		res := w.Result()
@@ -232,7 +245,7 @@ func Test_UpsertBulk_Valid(t *testing.T) {
 func Test_UpsertBulk_Error(t *testing.T) {
 	driverLocationRepository := new(mocks.Repository)
 	id, _ := primitive.ObjectIDFromHex("6219f72c61d60d9a30ff2072")
-	driverLocationRepository.On("UpsertBulk", context.TODO(), []*models.DriverLocation{
+	driverLocationRepository.On("UpsertBulk", mock.Anything, []*models.DriverLocation{
 		{
 			ID: id,
 			Location: models.Location{
@@ -240,12 +253,12 @@ func Test_UpsertBulk_Error(t *testing.T) {
 				Coordinates: []interface{}{40.94001079, 29.00077262},
 			},
 		},
-	}).Return(fmt.Errorf("err"))
+	}).Return(nil, fmt.Errorf("err"))
 
 	for _, data := range UpsertBulkErr {
-		driverLocationHandler := &httpServer{driverLocationRepository}
+		driverLocationHandler := &httpServer{repository: driverLocationRepository}
 		w := httptest.NewRecorder()
-		req := httptest.NewRequest(data.method, data.url, strings.NewReader(data.body))
+		req := withClaims(httptest.NewRequest(data.method, data.url, strings.NewReader(data.body)), adminClaims)
 		driverLocationHandler.UpsertBulk(w, req)
 
 		res := w.Result()
@@ -258,3 +271,125 @@ func Test_UpsertBulk_Error(t *testing.T) {
 		assert.Equal(t, data.expectedCode, w.Code)
 	}
 }
+
+func Test_UpsertBulk_Driver(t *testing.T) {
+	driverLocationRepository := new(mocks.Repository)
+	driverLocationRepository.On("UpsertBulk", mock.Anything, mock.Anything).Return([]*models.UpsertResult{{DriverID: "driver-1", Status: models.UpsertInserted}}, nil)
+	driverLocationHandler := &httpServer{repository: driverLocationRepository}
+
+	driver := jwt.MapClaims{"scope": auth.ScopeDriver, "driver_id": "driver-1"}
+	tests := []struct {
+		name         string
+		claims       jwt.MapClaims
+		body         string
+		expectedCode int
+	}{
+		{"own_driver_id", driver, `[{"driver_id":"driver-1","location":{"type":"Point","coordinates":[40.94001079,29.00077262]}}]`, 200},
+		{"other_driver_id", driver, `[{"driver_id":"driver-2","location":{"type":"Point","coordinates":[40.94001079,29.00077262]}}]`, 403},
+		{"mixed_driver_ids", driver, `[{"driver_id":"driver-1","location":{"type":"Point","coordinates":[40.94001079,29.00077262]}},{"driver_id":"driver-2","location":{"type":"Point","coordinates":[40.94001079,29.00077262]}}]`, 403},
+		{"other_id", driver, `[{"_id":"6219f72c61d60d9a30ff2072","driver_id":"driver-1","location":{"type":"Point","coordinates":[40.94001079,29.00077262]}}]`, 403},
+		{"no_driver_id", driver, `[{"_id":"6219f72c61d60d9a30ff2072","location":{"type":"Point","coordinates":[40.94001079,29.00077262]}}]`, 403},
+		{"no_driver_id_claim", jwt.MapClaims{"scope": auth.ScopeDriver}, `[{"driver_id":"","location":{"type":"Point","coordinates":[40.94001079,29.00077262]}}]`, 403},
+		{"dispatch", jwt.MapClaims{"scope": auth.ScopeDispatch, "driver_id": "driver-1"}, `[{"driver_id":"driver-1","location":{"type":"Point","coordinates":[40.94001079,29.00077262]}}]`, 403},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := withClaims(httptest.NewRequest(http.MethodPost, "/api/v1/driver_locations/", strings.NewReader(test.body)), test.claims)
+			driverLocationHandler.UpsertBulk(w, req)
+			assert.Equal(t, test.expectedCode, w.Code)
+		})
+	}
+
+	driverLocationRepository.AssertNumberOfCalls(t, "UpsertBulk", 1)
+}
+
+func Test_Migrate(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	driverLocationRepository := new(mocks.Repository)
+	driverLocationRepository.On("Migrate", mock.Anything).Run(func(args mock.Arguments) {
+		// the migration does not use the request context
+		assert.Nil(t, args.Get(0).(context.Context).Err())
+		close(started)
+		<-release
+	}).Return(nil).Once()
+	driverLocationRepository.On("Migrate", mock.Anything).Return(errors.New("error")).Once()
+	driverLocationHandler := &httpServer{repository: driverLocationRepository}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := httptest.NewRecorder()
+	driverLocationHandler.Migrate(w, httptest.NewRequest(http.MethodPost, "/api/v1/driver_locations/migrate", nil).WithContext(ctx))
+	cancel()
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, `{"code":202,"msg":"driver locations are being migrated"}`, w.Body.String())
+
+	// only one migration runs at a time
+	<-started
+	w = httptest.NewRecorder()
+	driverLocationHandler.Migrate(w, httptest.NewRequest(http.MethodPost, "/api/v1/driver_locations/migrate", nil))
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		w = httptest.NewRecorder()
+		driverLocationHandler.Migrate(w, httptest.NewRequest(http.MethodPost, "/api/v1/driver_locations/migrate", nil))
+		return w.Code == http.StatusAccepted
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		driverLocationHandler.mu.Lock()
+		defer driverLocationHandler.mu.Unlock()
+		return !driverLocationHandler.migrating
+	}, time.Second, 10*time.Millisecond)
+	driverLocationRepository.AssertNumberOfCalls(t, "Migrate", 2)
+}
+
+func Test_Migrate_Shutdown(t *testing.T) {
+	started, canceled := make(chan struct{}), make(chan struct{})
+	driverLocationRepository := new(mocks.Repository)
+	driverLocationRepository.On("Migrate", mock.Anything).Run(func(args mock.Arguments) {
+		close(started)
+		<-args.Get(0).(context.Context).Done()
+		close(canceled)
+	}).Return(context.Canceled).Once()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	driverLocationHandler := &httpServer{repository: driverLocationRepository, ctx: ctx}
+
+	w := httptest.NewRecorder()
+	driverLocationHandler.Migrate(w, httptest.NewRequest(http.MethodPost, "/api/v1/driver_locations/migrate", nil))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	// the migration is canceled with the server and waited for
+	<-started
+	cancel()
+	driverLocationHandler.migrations.Wait()
+
+	select {
+	case <-canceled:
+	default:
+		t.Fatal("the migration was not canceled")
+	}
+	driverLocationRepository.AssertNumberOfCalls(t, "Migrate", 1)
+}
+
+func Test_Ready(t *testing.T) {
+	t.Setenv("REPOSITORY", "mongo")
+	driverLocationRepository := new(mocks.Repository)
+	driverLocationRepository.On("Ping", mock.Anything).Return(nil)
+	driverLocationRepository.On("CheckIndex", mock.Anything).Return(nil).Once()
+	driverLocationRepository.On("CheckIndex", mock.Anything).Return(repository.ErrIndexMissing).Once()
+	driverLocationHandler := &httpServer{repository: driverLocationRepository}
+	ready := health.Ready(readyChecks(&driverLocationHandler.readiness, driverLocationRepository))
+
+	w := httptest.NewRecorder()
+	ready(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"status":"up","components":{"index":{"status":"up"},"repository":{"status":"up","details":{"backend":"mongo"}},"server":{"status":"up"}}}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	ready(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, `{"status":"down","components":{"index":{"status":"down","error":"geo index of driver locations does not exist"},"repository":{"status":"up","details":{"backend":"mongo"}},"server":{"status":"up"}}}`, w.Body.String())
+}
@@ -1,18 +1,72 @@
 package middlewares
 
 import (
+	"errors"
 	"net/http"
+	"strings"
 
+	"github.com/golang-jwt/jwt"
 	"github.com/s3f4/locationmatcher/pkg/apihelper"
+	"github.com/s3f4/locationmatcher/pkg/auth"
+	"github.com/s3f4/locationmatcher/pkg/signature"
 )
 
+var (
+	// verifier verifies the signed requests of other services, it is built
+	// from SERVICE_SIGNING_KEYS and can be replaced by SetVerifier.
+	verifier = signature.NewVerifier(signature.KeysFromEnv())
+	// tokenVerifier verifies the bearer tokens of driver apps, dispatch
+	// backends and admins, it can be replaced by SetTokenVerifier.
+	tokenVerifier = auth.VerifierFromEnv()
+
+	// errUnauthorized is returned when the authorization is not a bearer token
+	errUnauthorized = errors.New("unauthorized")
+)
+
+// serviceScope is granted to requests signed by other services, matching
+// finds nearest drivers for dispatch.
+const serviceScope = auth.ScopeDispatch
+
+// SetVerifier replaces the service request verifier of AuthCtx and AuthInterceptor
+func SetVerifier(v *signature.Verifier) {
+	verifier = v
+}
+
+// SetTokenVerifier replaces the token verifier of AuthCtx and AuthInterceptor
+func SetTokenVerifier(v *auth.Verifier) {
+	tokenVerifier = v
+}
+
+// AuthCtx accepts requests with a bearer token or signed by other services
+// and puts their claims into the request context.
 func AuthCtx(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Header.Get("X-USER-AUTHENTICATED") != "true" {
+		var claims jwt.MapClaims
+		var err error
+		if token := bearerToken(r.Header.Get("Authorization")); token != "" {
+			claims, err = tokenVerifier.Verify(r.Context(), token)
+		} else if err = verifier.VerifyRequest(r); err == nil {
+			claims = serviceClaims(r.Header.Get(signature.HeaderKeyID))
+		}
+
+		if err != nil {
 			apihelper.Send401(w)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(auth.WithClaims(r.Context(), claims)))
 	})
 }
+
+// serviceClaims are the claims of a request signed with keyID
+func serviceClaims(keyID string) jwt.MapClaims {
+	return jwt.MapClaims{"sub": "service:" + keyID, "scope": serviceScope}
+}
+
+// bearerToken returns the token of an authorization header
+func bearerToken(header string) string {
+	if len(header) > 7 && strings.ToUpper(header[0:6]) == "BEARER" {
+		return header[7:]
+	}
+	return ""
+}
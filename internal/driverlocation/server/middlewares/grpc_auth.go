@@ -0,0 +1,55 @@
+package middlewares
+
+import (
+	"context"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/s3f4/locationmatcher/pkg/auth"
+	"github.com/s3f4/locationmatcher/pkg/signature"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// methodScopes are the scopes required by the gRPC methods, the scopes of
+// other methods such as health checks are not checked.
+var methodScopes = map[string][]string{
+	"/driverlocation.v1.DriverLocationService/UpsertBulk":  {auth.ScopeDriver, auth.ScopeAdmin},
+	"/driverlocation.v1.DriverLocationService/FindNearest": {auth.ScopeDispatch},
+}
+
+// AuthInterceptor is the gRPC equivalent of AuthCtx and RequireScope, it
+// verifies the bearer token or the signature metadata of the incoming request.
+func AuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	claims, err := verifyUnary(ctx, info.FullMethod, req)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Unauthorized")
+	}
+
+	if scopes, ok := methodScopes[info.FullMethod]; ok && !auth.HasScope(claims, scopes...) {
+		return nil, status.Error(codes.PermissionDenied, "Forbidden")
+	}
+
+	return handler(auth.WithClaims(ctx, claims), req)
+}
+
+func verifyUnary(ctx context.Context, fullMethod string, req interface{}) (jwt.MapClaims, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) > 0 {
+		if token := bearerToken(values[0]); token != "" {
+			return tokenVerifier.Verify(ctx, token)
+		}
+		return nil, errUnauthorized
+	}
+
+	if err := verifier.VerifyUnary(ctx, fullMethod, req); err != nil {
+		return nil, err
+	}
+
+	var keyID string
+	if values := md.Get(signature.HeaderKeyID); len(values) > 0 {
+		keyID = values[0]
+	}
+	return serviceClaims(keyID), nil
+}
@@ -1,4 +1,7 @@
 //  The Driver Location Api
+//  Driver tokens (scope driver) may upsert the locations of their own driver_id,
+//  dispatch tokens and signed service requests may find nearest drivers and
+//  admin tokens may upsert any driver location and run migrations.
 //   version: 0.0.1
 //   title: Driver Location Api
 //  Schemes: http, https
@@ -15,6 +18,8 @@
 // swagger:meta
 package server
 
+import "time"
+
 type Location struct {
 	// example: Point
 	Type string `json:"type"`
@@ -39,6 +44,15 @@ type DriverLocation struct {
 	// Id of the driver location
 	// in: string
 	ID string `json:"_id"`
+	// Id of the driver
+	// in: string
+	DriverID string `json:"driver_id"`
+	// Status of the driver, empty is stored as available
+	// enum: available,busy,offline
+	Status string `json:"status"`
+	// Time of the last upsert
+	// readonly: true
+	UpdatedAt time.Time `json:"updated_at"`
 	// Id of the driver location
 	// in: Location
 	Location Location `json:"location"`
@@ -61,6 +75,17 @@ type Query struct {
 	// in: float64
 	// example: 10000
 	MaxDistance int64 `json:"maxDistance"`
+	// Maximum number of nearest locations, 0 returns all
+	// in: int64
+	// maximum: 1000
+	// example: 10
+	Limit int64 `json:"limit"`
+	// Number of nearest locations to skip
+	// in: int64
+	Offset int64 `json:"offset"`
+	// Status filter of drivers, empty returns all
+	// enum: available,busy,offline
+	Status string `json:"status"`
 }
 
 // swagger:parameters v1 Find
@@ -92,6 +117,34 @@ type ReqUpsertBulk struct {
 	Body []*DriverLocation `json:"body"`
 }
 
+// swagger:model UpsertResult
+type UpsertResult struct {
+	// Id of the driver location
+	// in: string
+	ID string `json:"_id"`
+	// Id of the driver
+	// in: string
+	DriverID string `json:"driver_id"`
+	// Result of the upsert
+	// enum: inserted,updated,failed
+	Status string `json:"status"`
+	// Error of failed upserts
+	// in: string
+	Error string `json:"error"`
+}
+
+// swagger:response UpsertResults
+type UpsertResultsBody struct {
+	// - name: body
+	//  in: body
+	//  description: results in the order of the request
+	//  schema:
+	//  type: array
+	//     "$ref": "#/definitions/UpsertResult"
+	//  required: true
+	Body []*UpsertResult `json:"body"`
+}
+
 type ApiError struct {
 	// Status Code of the error
 	// in: int
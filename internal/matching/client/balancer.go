@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// Balancers of driverlocation endpoints
+const (
+	RoundRobin       = "round_robin"
+	LeastOutstanding = "least_outstanding"
+)
+
+// endpoint is a driverlocation instance with its own circuit breaker
+type endpoint struct {
+	// outstanding is first to be 64-bit aligned for atomic operations
+	outstanding int64
+	baseURL     string
+	breaker     *CircuitBreaker
+}
+
+// do sends a request to path through the breaker of e and counts it as
+// outstanding until the response headers are received.
+func (e *endpoint) do(ctx context.Context, path string, reader io.Reader) (*http.Response, error) {
+	atomic.AddInt64(&e.outstanding, 1)
+	defer atomic.AddInt64(&e.outstanding, -1)
+	return e.breaker.Call(ctx, e.baseURL+path, reader)
+}
+
+// balancer chooses the endpoint of the next request
+type balancer interface {
+	next(endpoints []*endpoint) *endpoint
+}
+
+func newBalancer(name string) (balancer, error) {
+	switch name {
+	case "", RoundRobin:
+		return new(roundRobin), nil
+	case LeastOutstanding:
+		return new(leastOutstanding), nil
+	default:
+		return nil, fmt.Errorf("no such balancer %s", name)
+	}
+}
+
+// roundRobin chooses endpoints in turn
+type roundRobin struct {
+	n uint64
+}
+
+func (r *roundRobin) next(endpoints []*endpoint) *endpoint {
+	n := atomic.AddUint64(&r.n, 1) - 1
+	return endpoints[n%uint64(len(endpoints))]
+}
+
+// leastOutstanding chooses the endpoint with the fewest requests in flight
+type leastOutstanding struct{}
+
+func (leastOutstanding) next(endpoints []*endpoint) *endpoint {
+	chosen := endpoints[0]
+	for _, e := range endpoints[1:] {
+		if atomic.LoadInt64(&e.outstanding) < atomic.LoadInt64(&chosen.outstanding) {
+			chosen = e
+		}
+	}
+	return chosen
+}
+
+// healthy returns the endpoints whose circuit is closed or half-open with a
+// free probe, so tripped endpoints are probed and rejoin the rotation. All
+// endpoints are returned when none is available so that requests fail fast.
+func healthy(endpoints []*endpoint) []*endpoint {
+	available := make([]*endpoint, 0, len(endpoints))
+	for _, e := range endpoints {
+		if e.breaker.available() {
+			available = append(available, e)
+		}
+	}
+
+	if len(available) > 0 {
+		return available
+	}
+	return endpoints
+}
@@ -7,52 +7,267 @@ import (
 	"net/http"
 	"sync"
 	"time"
-
-	"github.com/s3f4/locationmatcher/pkg/log"
 )
 
+// ErrServiceUnreachable is returned while the circuit is open, or half-open
+// with all of its probes in flight.
+var ErrServiceUnreachable = errors.New("service unreachable")
+
 type Circuit func(ctx context.Context, url string, reader io.Reader) (*http.Response, error)
 
-// The Breaker function accepts any function that conforms to the Circuit type
-// definition, and an unsigned integer representing the number of consecutive failures
-// allowed before the circuit automatically opens.
-// In return it provides another function,
-// which also conforms to the Circuit type definition:
-func Breaker(circuit Circuit, failureTreshold uint) Circuit {
-	var consecutiveFailures int = 0
-	var lastAttempt = time.Now()
-	var m sync.RWMutex
-
-	return func(ctx context.Context, url string, reader io.Reader) (*http.Response, error) {
-		m.RLock() // Establish a "read lock"
-		d := consecutiveFailures - int(failureTreshold)
-
-		if d >= 0 {
-			shouldRetryAt := lastAttempt.Add(time.Second * 2 << d)
-			log.Info(time.Now())
-			log.Info(shouldRetryAt)
-			if !time.Now().After(shouldRetryAt) {
-				m.RUnlock()
-				log.Info("service unreachable")
-				return nil, errors.New("service unreachable")
+// State is the state of a CircuitBreaker
+type State int
+
+const (
+	// StateClosed lets every request through and counts consecutive failures
+	StateClosed State = iota
+	// StateOpen rejects every request until the open timeout elapses
+	StateOpen
+	// StateHalfOpen lets a limited number of probes through, the circuit
+	// closes when they all succeed and opens again when one of them fails.
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerConfig configures a CircuitBreaker, zero values use the defaults
+type BreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that opens the
+	// circuit, it defaults to 5.
+	FailureThreshold uint
+	// OpenTimeout is how long the circuit stays open before it is probed, it
+	// doubles after every failed probe up to MaxOpenTimeout. It defaults to 2s.
+	OpenTimeout time.Duration
+	// MaxOpenTimeout defaults to one minute
+	MaxOpenTimeout time.Duration
+	// HalfOpenProbes is the number of successful probes that closes the
+	// circuit, it defaults to 1.
+	HalfOpenProbes uint
+	// IsFailure classifies the result of a request, it defaults to
+	// DefaultIsFailure.
+	IsFailure func(*http.Response, error) bool
+	// OnStateChange is called after every state change
+	OnStateChange func(from, to State)
+}
+
+// DefaultIsFailure counts errors, including timeouts, and 429 and 5xx
+// responses as failures. Requests canceled by the caller are not failures.
+func DefaultIsFailure(response *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	return response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= http.StatusInternalServerError
+}
+
+// CircuitBreaker is a closed, open and half-open circuit breaker
+type CircuitBreaker struct {
+	circuit Circuit
+	config  BreakerConfig
+	now     func() time.Time
+
+	m                   sync.Mutex
+	state               State
+	generation          uint64
+	consecutiveFailures uint
+	openedAt            time.Time
+	openTimeout         time.Duration
+	probes              uint
+	successes           uint
+}
+
+// NewBreaker returns a closed CircuitBreaker for circuit
+func NewBreaker(circuit Circuit, config BreakerConfig) *CircuitBreaker {
+	if config.FailureThreshold == 0 {
+		config.FailureThreshold = 5
+	}
+	if config.OpenTimeout == 0 {
+		config.OpenTimeout = 2 * time.Second
+	}
+	if config.MaxOpenTimeout == 0 {
+		config.MaxOpenTimeout = time.Minute
+	}
+	if config.HalfOpenProbes == 0 {
+		config.HalfOpenProbes = 1
+	}
+	if config.IsFailure == nil {
+		config.IsFailure = DefaultIsFailure
+	}
+
+	return &CircuitBreaker{
+		circuit:     circuit,
+		config:      config,
+		now:         time.Now,
+		openTimeout: config.OpenTimeout,
+	}
+}
+
+// State returns the current state, an open circuit whose timeout elapsed is
+// half-open.
+func (b *CircuitBreaker) State() State {
+	b.m.Lock()
+	state, changes := b.refresh()
+	b.m.Unlock()
+
+	b.notify(changes)
+	return state
+}
+
+// available reports whether a request would be admitted, the circuit is
+// closed or half-open with a free probe.
+func (b *CircuitBreaker) available() bool {
+	b.m.Lock()
+	state, changes := b.refresh()
+	available := state == StateClosed || state == StateHalfOpen && b.probes < b.config.HalfOpenProbes
+	b.m.Unlock()
+
+	b.notify(changes)
+	return available
+}
+
+// Call sends a request through the circuit. Responses classified as failures
+// are returned to the caller and counted by the breaker.
+func (b *CircuitBreaker) Call(ctx context.Context, url string, reader io.Reader) (*http.Response, error) {
+	generation, err := b.admit()
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := b.circuit(ctx, url, reader)
+
+	failure := b.config.IsFailure(response, err)
+	canceled := err != nil && !failure
+	b.record(generation, failure, canceled)
+
+	return response, err
+}
+
+// admit lets a request through or rejects it with ErrServiceUnreachable, the
+// returned generation ties the result of the request to the current state.
+func (b *CircuitBreaker) admit() (uint64, error) {
+	b.m.Lock()
+	state, changes := b.refresh()
+
+	var err error
+	switch state {
+	case StateOpen:
+		err = ErrServiceUnreachable
+	case StateHalfOpen:
+		if b.probes >= b.config.HalfOpenProbes {
+			err = ErrServiceUnreachable
+		} else {
+			b.probes++
+		}
+	}
+	generation := b.generation
+	b.m.Unlock()
+
+	b.notify(changes)
+	return generation, err
+}
+
+// record counts the result of a request admitted in generation, results of
+// requests admitted before the last state change are ignored.
+func (b *CircuitBreaker) record(generation uint64, failure, canceled bool) {
+	b.m.Lock()
+	var changes []stateChange
+	if generation == b.generation {
+		switch b.state {
+		case StateClosed:
+			if canceled {
+				break
+			}
+			if !failure {
+				b.consecutiveFailures = 0
+				break
+			}
+
+			b.consecutiveFailures++
+			if b.consecutiveFailures >= b.config.FailureThreshold {
+				changes = b.open(b.config.OpenTimeout)
+			}
+		case StateHalfOpen:
+			switch {
+			case canceled:
+				// the probe did not tell anything, let another one through
+				b.probes--
+			case failure:
+				changes = b.open(b.nextOpenTimeout())
+			default:
+				b.successes++
+				if b.successes >= b.config.HalfOpenProbes {
+					changes = b.setState(StateClosed)
+					b.openTimeout = b.config.OpenTimeout
+				}
 			}
 		}
+	}
+	b.m.Unlock()
+
+	b.notify(changes)
+}
 
-		m.RUnlock() // Release read lock
+// stateChange is a state change whose callback is called after the lock is
+// released, callbacks may call State.
+type stateChange struct {
+	from, to State
+}
 
-		response, err := circuit(ctx, url, reader) // Issue request proper
+// refresh moves an open circuit whose timeout elapsed to half-open
+func (b *CircuitBreaker) refresh() (State, []stateChange) {
+	if b.state == StateOpen && !b.now().Before(b.openedAt.Add(b.openTimeout)) {
+		return StateHalfOpen, b.setState(StateHalfOpen)
+	}
+	return b.state, nil
+}
 
-		m.Lock() // Lock around shared resources
-		defer m.Unlock()
+func (b *CircuitBreaker) open(timeout time.Duration) []stateChange {
+	b.openedAt = b.now()
+	b.openTimeout = timeout
+	return b.setState(StateOpen)
+}
 
-		lastAttempt = time.Now() // Record time of attempt
+func (b *CircuitBreaker) nextOpenTimeout() time.Duration {
+	timeout := b.openTimeout * 2
+	if timeout > b.config.MaxOpenTimeout {
+		timeout = b.config.MaxOpenTimeout
+	}
+	return timeout
+}
 
-		if err != nil { // Circuit returned an error,
-			consecutiveFailures++ // so we count the failure
-			return response, err  // and return
-		}
+func (b *CircuitBreaker) setState(state State) []stateChange {
+	from := b.state
+	b.state = state
+	b.generation++
+	b.consecutiveFailures = 0
+	b.probes = 0
+	b.successes = 0
+	return []stateChange{{from: from, to: state}}
+}
 
-		consecutiveFailures = 0 // Reset failures counter
-		return response, nil
+func (b *CircuitBreaker) notify(changes []stateChange) {
+	if b.config.OnStateChange == nil {
+		return
 	}
+	for _, change := range changes {
+		b.config.OnStateChange(change.from, change.to)
+	}
+}
+
+// The Breaker function accepts any function that conforms to the Circuit type
+// definition, and an unsigned integer representing the number of consecutive failures
+// allowed before the circuit automatically opens.
+// In return it provides another function,
+// which also conforms to the Circuit type definition:
+func Breaker(circuit Circuit, failureTreshold uint) Circuit {
+	return NewBreaker(circuit, BreakerConfig{FailureThreshold: failureTreshold}).Call
 }
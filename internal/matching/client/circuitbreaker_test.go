@@ -0,0 +1,211 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCircuit returns the next response or error of its results
+type fakeCircuit struct {
+	mu      sync.Mutex
+	calls   int
+	results []func() (*http.Response, error)
+}
+
+func (f *fakeCircuit) push(result func() (*http.Response, error)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results = append(f.results, result)
+}
+
+func (f *fakeCircuit) circuit(ctx context.Context, url string, reader io.Reader) (*http.Response, error) {
+	f.mu.Lock()
+	f.calls++
+	result := f.results[0]
+	f.results = f.results[1:]
+	f.mu.Unlock()
+	return result()
+}
+
+func status(code int) func() (*http.Response, error) {
+	return func() (*http.Response, error) { return &http.Response{StatusCode: code}, nil }
+}
+
+func failure(err error) func() (*http.Response, error) {
+	return func() (*http.Response, error) { return nil, err }
+}
+
+func Test_CircuitBreaker(t *testing.T) {
+	f := new(fakeCircuit)
+	var changes []string
+	b := NewBreaker(f.circuit, BreakerConfig{
+		FailureThreshold: 2,
+		OpenTimeout:      time.Second,
+		MaxOpenTimeout:   3 * time.Second,
+		HalfOpenProbes:   2,
+		OnStateChange: func(from, to State) {
+			changes = append(changes, from.String()+"->"+to.String())
+		},
+	})
+	now := time.Now()
+	b.now = func() time.Time { return now }
+	call := func() error {
+		_, err := b.Call(context.Background(), "", nil)
+		return err
+	}
+
+	// 4xx responses and canceled requests are not failures
+	f.push(status(http.StatusInternalServerError))
+	f.push(status(http.StatusNotFound))
+	f.push(failure(context.Canceled))
+	f.push(status(http.StatusServiceUnavailable))
+	assert.Nil(t, call())
+	assert.Nil(t, call())
+	assert.Equal(t, context.Canceled, call())
+	assert.Nil(t, call())
+	assert.Equal(t, StateClosed, b.State())
+
+	// timeouts are failures and open the circuit
+	f.push(failure(context.DeadlineExceeded))
+	assert.Equal(t, context.DeadlineExceeded, call())
+	assert.Equal(t, StateOpen, b.State())
+
+	assert.Equal(t, ErrServiceUnreachable, call())
+	assert.Equal(t, 5, f.calls)
+
+	// a failed probe opens the circuit with a doubled timeout
+	now = now.Add(time.Second)
+	assert.Equal(t, StateHalfOpen, b.State())
+	f.push(failure(errors.New("refused")))
+	assert.NotNil(t, call())
+	assert.Equal(t, StateOpen, b.State())
+
+	now = now.Add(time.Second)
+	assert.Equal(t, StateOpen, b.State())
+	now = now.Add(time.Second)
+	assert.Equal(t, StateHalfOpen, b.State())
+
+	// only HalfOpenProbes probes are let through at once
+	release := make(chan struct{})
+	blocked := func() (*http.Response, error) {
+		<-release
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	}
+	f.push(blocked)
+	f.push(blocked)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, call())
+		}()
+	}
+
+	assert.Eventually(t, func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.calls == 8
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, ErrServiceUnreachable, call())
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, StateClosed, b.State())
+
+	assert.Equal(t, []string{
+		"closed->open",
+		"open->half-open",
+		"half-open->open",
+		"open->half-open",
+		"half-open->closed",
+	}, changes)
+
+	// the open timeout is reset when the circuit closes
+	f.push(failure(errors.New("refused")))
+	f.push(failure(errors.New("refused")))
+	call()
+	call()
+	now = now.Add(time.Second)
+	assert.Equal(t, StateHalfOpen, b.State())
+}
+
+func Test_CircuitBreaker_MaxOpenTimeout(t *testing.T) {
+	f := new(fakeCircuit)
+	b := NewBreaker(f.circuit, BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Second, MaxOpenTimeout: 2 * time.Second})
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	f.push(failure(errors.New("refused")))
+	b.Call(context.Background(), "", nil)
+
+	for i := 0; i < 3; i++ {
+		now = now.Add(2 * time.Second)
+		f.push(failure(errors.New("refused")))
+		b.Call(context.Background(), "", nil)
+		assert.Equal(t, StateOpen, b.State())
+	}
+
+	now = now.Add(2 * time.Second)
+	assert.Equal(t, StateHalfOpen, b.State())
+}
+
+func Test_CircuitBreaker_StaleResults(t *testing.T) {
+	f := new(fakeCircuit)
+	b := NewBreaker(f.circuit, BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Second})
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	// a request admitted while closed finishes after the circuit opened
+	release := make(chan struct{})
+	f.push(func() (*http.Response, error) {
+		<-release
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})
+	done := make(chan struct{})
+	go func() {
+		b.Call(context.Background(), "", nil)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.calls == 1
+	}, time.Second, time.Millisecond)
+
+	f.push(failure(errors.New("refused")))
+	b.Call(context.Background(), "", nil)
+	assert.Equal(t, StateOpen, b.State())
+
+	close(release)
+	<-done
+	assert.Equal(t, StateOpen, b.State())
+}
+
+func Test_CircuitBreaker_CustomClassifier(t *testing.T) {
+	f := new(fakeCircuit)
+	b := NewBreaker(f.circuit, BreakerConfig{
+		FailureThreshold: 1,
+		IsFailure: func(response *http.Response, err error) bool {
+			return err != nil || response.StatusCode == http.StatusNotFound
+		},
+	})
+
+	f.push(status(http.StatusInternalServerError))
+	b.Call(context.Background(), "", nil)
+	assert.Equal(t, StateClosed, b.State())
+
+	f.push(status(http.StatusNotFound))
+	response, err := b.Call(context.Background(), "", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	assert.Equal(t, StateOpen, b.State())
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Errors of driverlocation responses, ResponseError wraps them
+var (
+	// ErrNotFound is returned when no driver location matches the query
+	ErrNotFound = errors.New("no driver locations are found")
+	// ErrInvalidQuery is returned when driverlocation rejects the query
+	ErrInvalidQuery = errors.New("invalid query")
+	// ErrUnauthorized is returned when driverlocation rejects the credentials
+	// of the client
+	ErrUnauthorized = errors.New("unauthorized by driverlocation")
+	// ErrUnavailable is returned for 429 and 5xx responses
+	ErrUnavailable = errors.New("driverlocation is unavailable")
+	// ErrUnexpectedResponse is returned for responses that do not match the
+	// driverlocation schema
+	ErrUnexpectedResponse = errors.New("unexpected driverlocation response")
+)
+
+// ResponseError is an error response of driverlocation
+type ResponseError struct {
+	Code int
+	Msg  string
+	Err  error
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("driverlocation responded %d %s: %s", e.Code, e.Msg, e.Err)
+}
+
+func (e *ResponseError) Unwrap() error {
+	return e.Err
+}
+
+// responseError maps the code of a driverlocation error response to a typed
+// error
+func responseError(code int, msg string) error {
+	var err error
+	switch {
+	case code == http.StatusNotFound:
+		err = ErrNotFound
+	case code == http.StatusBadRequest || code == http.StatusUnprocessableEntity:
+		err = ErrInvalidQuery
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		err = ErrUnauthorized
+	case retryableStatus(code):
+		err = ErrUnavailable
+	default:
+		err = ErrUnexpectedResponse
+	}
+
+	if msg == "" {
+		msg = http.StatusText(code)
+	}
+	return &ResponseError{Code: code, Msg: msg, Err: err}
+}
@@ -4,73 +4,357 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/s3f4/locationmatcher/internal/matching/models"
-	"github.com/s3f4/locationmatcher/pkg/apihelper"
 	"github.com/s3f4/locationmatcher/pkg/log"
+	"github.com/s3f4/locationmatcher/pkg/requestid"
+	"github.com/s3f4/locationmatcher/pkg/signature"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// findNearestPath is the find_nearest endpoint of the driverlocation service
+const findNearestPath = "/api/v1/driver_locations/find_nearest"
+
+// tracerName is the instrumentation name of the spans of the client
+const tracerName = "github.com/s3f4/locationmatcher/internal/matching/client"
+
+// defaultURL is the driverlocation instance used when DRIVER_LOCATION_URLS is not set
+const defaultURL = "http://driverlocation:3001"
+
+// ErrNoEndpoints is returned when the client has no driverlocation endpoints
+var ErrNoEndpoints = errors.New("no driverlocation endpoints are configured")
+
 type APIClient interface {
-	FindNearest(context.Context, string, *models.Query) (*apihelper.Response, error)
+	// FindNearest returns the nearest driver locations of the query, error
+	// responses of driverlocation are returned as *ResponseError.
+	FindNearest(context.Context, *models.Query) (*models.LocationsResponse, error)
+	// Endpoints returns the circuit state of every driverlocation endpoint
+	// by base URL
+	Endpoints() map[string]State
+}
+
+// Config configures the driverlocation endpoints of the client
+type Config struct {
+	// URLs are the base URLs of the driverlocation instances
+	URLs []string
+	// Balancer is RoundRobin or LeastOutstanding, it defaults to RoundRobin
+	Balancer string
+	// Breaker configures the circuit breaker of every endpoint
+	Breaker BreakerConfig
+	// Retry configures retries of failed requests
+	Retry RetryConfig
+	// Hedge sends a second request to another endpoint when the first one
+	// takes longer than the p95 latency of recent requests.
+	Hedge bool
+	// Timeout is the timeout of a request
+	Timeout time.Duration
+}
+
+// ConfigFromEnv reads DRIVER_LOCATION_URLS, comma separated base URLs,
+// DRIVER_LOCATION_BALANCER, DRIVER_LOCATION_HEDGE and the retry config.
+func ConfigFromEnv() Config {
+	config := Config{
+		Balancer: os.Getenv("DRIVER_LOCATION_BALANCER"),
+		Retry:    retryConfigFromEnv(),
+		Hedge:    os.Getenv("DRIVER_LOCATION_HEDGE") == "true",
+		Timeout:  time.Second * 15,
+	}
+
+	for _, url := range strings.Split(os.Getenv("DRIVER_LOCATION_URLS"), ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			config.URLs = append(config.URLs, url)
+		}
+	}
+
+	if len(config.URLs) == 0 {
+		config.URLs = []string{defaultURL}
+	}
+
+	return config
 }
 
 type httpClient struct {
-	client *http.Client
+	client    *http.Client
+	signer    *signature.Signer
+	endpoints []*endpoint
+	balancer  balancer
+	retry     RetryConfig
+	hedge     bool
+	latencies latencies
 }
 
-var client *httpClient
-var circuit Circuit
+var client APIClient
 
+// NewAPIClient returns the client configured by the environment
 func NewAPIClient() APIClient {
 	if client == nil {
-		client = new(httpClient)
-		client.client = &http.Client{
-			Timeout: time.Second * 15,
+		c, err := New(ConfigFromEnv())
+		if err != nil {
+			log.Fatal(err)
 		}
+		client = c
+	}
 
-		circuit = Breaker(func(ctx context.Context, url string, reader io.Reader) (*http.Response, error) {
-			req, err := http.NewRequest("POST", url, reader)
-			if err != nil {
-				return nil, err
-			}
+	return client
+}
+
+// New returns a client that balances requests between the endpoints of config
+func New(config Config) (APIClient, error) {
+	if len(config.URLs) == 0 {
+		return nil, ErrNoEndpoints
+	}
+
+	balancer, err := newBalancer(config.Balancer)
+	if err != nil {
+		return nil, err
+	}
 
-			req = req.WithContext(ctx)
-			req.Header.Set("X-USER-AUTHENTICATED", "true")
+	c := &httpClient{
+		client: &http.Client{
+			// requests are traced and carry the traceparent of their context
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+			Timeout:   config.Timeout,
+		},
+		signer:   signature.SignerFromEnv(),
+		balancer: balancer,
+		retry:    config.Retry.withDefaults(),
+		hedge:    config.Hedge,
+	}
 
-			resp, err := client.client.Do(req)
-			if err != nil {
-				return nil, err
+	for _, url := range config.URLs {
+		baseURL := strings.TrimRight(url, "/")
+		breakerConfig := config.Breaker
+		breakerConfig.OnStateChange = func(from, to State) {
+			log.Infof("driverlocation %s circuit %s -> %s", baseURL, from, to)
+			observeState(baseURL, to)
+			if config.Breaker.OnStateChange != nil {
+				config.Breaker.OnStateChange(from, to)
 			}
+		}
 
-			return resp, nil
-		}, 5)
+		observeState(baseURL, StateClosed)
+		c.endpoints = append(c.endpoints, &endpoint{
+			baseURL: baseURL,
+			breaker: NewBreaker(c.post, breakerConfig),
+		})
 	}
 
-	return client
+	return c, nil
 }
 
-func (a *httpClient) FindNearest(ctx context.Context, url string, query *models.Query) (*apihelper.Response, error) {
-	newReq, err := json.Marshal(query)
+// post sends a signed POST request
+func (a *httpClient) post(ctx context.Context, url string, reader io.Reader) (*http.Response, error) {
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(ctx)
+	if id, ok := requestid.FromContext(ctx); ok {
+		req.Header.Set(requestid.Header, id)
+	}
+	if a.signer != nil {
+		if err := a.signer.Sign(req, body); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := a.client.Do(req)
 	if err != nil {
-		log.Error(err)
 		return nil, err
 	}
 
-	reader := io.NopCloser(bytes.NewReader(newReq))
-	resp, err := circuit(ctx, url, reader)
+	return resp, nil
+}
+
+func (a *httpClient) Endpoints() map[string]State {
+	states := make(map[string]State, len(a.endpoints))
+	for _, e := range a.endpoints {
+		states[e.baseURL] = e.breaker.State()
+	}
+	return states
+}
+
+// result is the result of an attempt, status is 0 when no response was received
+type result struct {
+	response *models.LocationsResponse
+	status   int
+	err      error
+}
+
+func (r result) retryable() bool {
+	if r.status != 0 {
+		return retryableStatus(r.status)
+	}
+	return r.err != nil
+}
+
+// failed reports whether driverlocation could not answer, transport errors
+// and 5xx responses are failures, 4xx responses and canceled requests are not.
+func (r result) failed() bool {
+	if r.err == nil || errors.Is(r.err, context.Canceled) {
+		return false
+	}
+	return r.status == 0 || r.status >= http.StatusInternalServerError
+}
+
+// FindNearest retries failed attempts with a jittered exponential backoff
+// while the deadline of ctx leaves time for another attempt.
+func (a *httpClient) FindNearest(ctx context.Context, query *models.Query) (*models.LocationsResponse, error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "driverlocation.FindNearest", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	body, err := json.Marshal(query)
 	if err != nil {
-		// log.Error(err)
+		log.FromContext(ctx).Error(err)
 		return nil, err
 	}
+
+	requestsTotal.Inc()
+
+	var r result
+	retry := uint(0)
+	for ; ; retry++ {
+		r = a.send(ctx, body)
+		if !r.retryable() || retry >= a.retry.MaxRetries || ctx.Err() != nil {
+			break
+		}
+		if !wait(ctx, a.retry.backoff(retry)) {
+			break
+		}
+		retries.Inc()
+	}
+
+	span.SetAttributes(attribute.Int("retries", int(retry)))
+	if r.failed() {
+		failures.Inc()
+	}
+	if r.err != nil {
+		span.RecordError(r.err)
+		span.SetStatus(codes.Error, r.err.Error())
+	}
+	return r.response, r.err
+}
+
+// send sends body to a healthy endpoint. When hedging is enabled and no
+// result is received within the p95 latency, body is also sent to another
+// endpoint and the first result that is not retryable is used.
+func (a *httpClient) send(ctx context.Context, body []byte) result {
+	endpoints := healthy(a.endpoints)
+	first := a.balancer.next(endpoints)
+
+	delay, ok := a.latencies.quantile(hedgeQuantile)
+	if !a.hedge || !ok || len(endpoints) < 2 {
+		return a.attempt(ctx, first, body)
+	}
+
+	// the slower request is canceled when send returns
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type hedgeResult struct {
+		result
+		hedged bool
+	}
+	results := make(chan hedgeResult, 2)
+	go func() {
+		results <- hedgeResult{result: a.attempt(ctx, first, body)}
+	}()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case r := <-results:
+		return r.result
+	case <-timer.C:
+	}
+
+	others := make([]*endpoint, 0, len(endpoints)-1)
+	for _, e := range endpoints {
+		if e != first {
+			others = append(others, e)
+		}
+	}
+	second := a.balancer.next(others)
+
+	hedges.Inc()
+	trace.SpanFromContext(ctx).AddEvent("hedge", trace.WithAttributes(attribute.String("endpoint", second.baseURL)))
+	go func() {
+		results <- hedgeResult{result: a.attempt(ctx, second, body), hedged: true}
+	}()
+
+	r := <-results
+	if r.retryable() {
+		r = <-results
+	}
+	if r.hedged && !r.retryable() {
+		hedgeWins.Inc()
+	}
+	return r.result
+}
+
+// attempt sends body to the find_nearest endpoint of e and decodes the
+// response, latencies of successful attempts are recorded for hedging.
+func (a *httpClient) attempt(ctx context.Context, e *endpoint, body []byte) result {
+	start := time.Now()
+	resp, err := e.do(ctx, findNearestPath, bytes.NewReader(body))
+	if err != nil {
+		return result{err: err}
+	}
 	defer resp.Body.Close()
 
-	var response apihelper.Response
+	var response struct {
+		Code int                       `json:"code"`
+		Msg  string                    `json:"msg"`
+		Data *models.LocationsResponse `json:"data"`
+	}
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, err
+		if retryableStatus(resp.StatusCode) {
+			return result{status: resp.StatusCode, err: responseError(resp.StatusCode, "")}
+		}
+		return result{status: resp.StatusCode, err: fmt.Errorf("%w: %s", ErrUnexpectedResponse, err)}
+	}
+
+	// the code of an error body is more specific than the status, 401
+	// responses are sent with a 400 status
+	code := resp.StatusCode
+	if code >= http.StatusBadRequest && response.Code >= http.StatusBadRequest {
+		code = response.Code
+	}
+
+	if !retryableStatus(code) {
+		a.latencies.add(time.Since(start))
+	}
+
+	if code != http.StatusOK {
+		return result{status: code, err: responseError(code, response.Msg)}
+	}
+
+	if response.Data == nil {
+		return result{status: code, err: fmt.Errorf("%w: no data", ErrUnexpectedResponse)}
+	}
+
+	if len(response.Data.Locations) == 0 {
+		return result{status: code, err: responseError(http.StatusNotFound, "")}
 	}
 
-	return &response, nil
+	return result{response: response.Data, status: code}
 }
@@ -0,0 +1,408 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/s3f4/locationmatcher/internal/matching/models"
+	"github.com/s3f4/locationmatcher/pkg/requestid"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// foundBody is a find_nearest response with one driver location
+const foundBody = `{"code":200,"data":{"total":1,"locations":[{"_id":"6219f72c61d60d9a30ff2072","location":{"type":"Point","coordinates":[41.9,29.1]},"distance":6.3}]}}`
+
+// counts holds the values of the request counters
+type counts struct {
+	requests, retries, hedges, hedgeWins, failures float64
+}
+
+func readCounts() counts {
+	return counts{
+		requests:  testutil.ToFloat64(requestsTotal),
+		retries:   testutil.ToFloat64(retries),
+		hedges:    testutil.ToFloat64(hedges),
+		hedgeWins: testutil.ToFloat64(hedgeWins),
+		failures:  testutil.ToFloat64(failures),
+	}
+}
+
+func (c counts) sub(o counts) counts {
+	return counts{
+		requests:  c.requests - o.requests,
+		retries:   c.retries - o.retries,
+		hedges:    c.hedges - o.hedges,
+		hedgeWins: c.hedgeWins - o.hedgeWins,
+		failures:  c.failures - o.failures,
+	}
+}
+
+// countingServer serves find_nearest and counts its requests
+func countingServer(t *testing.T, handler func(w http.ResponseWriter, r *http.Request)) (*httptest.Server, *int64) {
+	var count int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, findNearestPath, r.URL.Path)
+		atomic.AddInt64(&count, 1)
+		if handler != nil {
+			handler(w, r)
+		}
+		w.Write([]byte(foundBody))
+	}))
+	t.Cleanup(server.Close)
+	return server, &count
+}
+
+func Test_New(t *testing.T) {
+	_, err := New(Config{})
+	assert.Equal(t, ErrNoEndpoints, err)
+
+	_, err = New(Config{URLs: []string{defaultURL}, Balancer: "random"})
+	assert.NotNil(t, err)
+}
+
+func Test_ConfigFromEnv(t *testing.T) {
+	config := ConfigFromEnv()
+	assert.Equal(t, []string{defaultURL}, config.URLs)
+	assert.Equal(t, RetryConfig{MaxRetries: defaultRetries}, config.Retry)
+	assert.False(t, config.Hedge)
+
+	t.Setenv("DRIVER_LOCATION_URLS", "http://driverlocation-1:3001, http://driverlocation-2:3001/")
+	t.Setenv("DRIVER_LOCATION_BALANCER", LeastOutstanding)
+	t.Setenv("DRIVER_LOCATION_RETRIES", "0")
+	t.Setenv("DRIVER_LOCATION_RETRY_BACKOFF", "10ms")
+	t.Setenv("DRIVER_LOCATION_HEDGE", "true")
+	config = ConfigFromEnv()
+	assert.Equal(t, []string{"http://driverlocation-1:3001", "http://driverlocation-2:3001/"}, config.URLs)
+	assert.Equal(t, LeastOutstanding, config.Balancer)
+	assert.Equal(t, RetryConfig{BaseBackoff: 10 * time.Millisecond}, config.Retry)
+	assert.True(t, config.Hedge)
+}
+
+func Test_FindNearest_RoundRobin(t *testing.T) {
+	first, firstCount := countingServer(t, nil)
+	second, secondCount := countingServer(t, nil)
+
+	c, err := New(Config{URLs: []string{first.URL, second.URL + "/"}, Breaker: BreakerConfig{FailureThreshold: 1}})
+	assert.Nil(t, err)
+
+	for i := 0; i < 4; i++ {
+		response, err := c.FindNearest(context.Background(), &models.Query{})
+		assert.Nil(t, err)
+		assert.Len(t, response.Locations, 1)
+	}
+
+	assert.Equal(t, int64(2), atomic.LoadInt64(firstCount))
+	assert.Equal(t, int64(2), atomic.LoadInt64(secondCount))
+}
+
+func Test_FindNearest_LeastOutstanding(t *testing.T) {
+	received, release := make(chan struct{}), make(chan struct{})
+	slow, slowCount := countingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-release
+	})
+	fast, fastCount := countingServer(t, nil)
+
+	c, err := New(Config{URLs: []string{slow.URL, fast.URL}, Balancer: LeastOutstanding, Breaker: BreakerConfig{FailureThreshold: 1}})
+	assert.Nil(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		c.FindNearest(context.Background(), &models.Query{})
+		close(done)
+	}()
+	<-received
+
+	// the slow endpoint has an outstanding request
+	for i := 0; i < 3; i++ {
+		_, err := c.FindNearest(context.Background(), &models.Query{})
+		assert.Nil(t, err)
+	}
+
+	close(release)
+	<-done
+	assert.Equal(t, int64(1), atomic.LoadInt64(slowCount))
+	assert.Equal(t, int64(3), atomic.LoadInt64(fastCount))
+}
+
+func Test_FindNearest_EjectsOpenEndpoints(t *testing.T) {
+	down, _ := countingServer(t, nil)
+	down.Close()
+	up, upCount := countingServer(t, nil)
+
+	c, err := New(Config{URLs: []string{down.URL, up.URL}, Breaker: BreakerConfig{FailureThreshold: 1}})
+	assert.Nil(t, err)
+
+	// the first request to the down endpoint opens its circuit
+	_, err = c.FindNearest(context.Background(), &models.Query{})
+	assert.NotNil(t, err)
+
+	for i := 0; i < 4; i++ {
+		_, err := c.FindNearest(context.Background(), &models.Query{})
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, int64(4), atomic.LoadInt64(upCount))
+}
+
+func Test_FindNearest_Recovers(t *testing.T) {
+	var down int32 = 1
+	flaky, flakyCount := countingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	up, _ := countingServer(t, nil)
+
+	c, err := New(Config{
+		URLs:    []string{flaky.URL, up.URL},
+		Breaker: BreakerConfig{FailureThreshold: 1, OpenTimeout: 20 * time.Millisecond},
+		Retry:   RetryConfig{MaxRetries: 1, BaseBackoff: time.Millisecond},
+	})
+	assert.Nil(t, err)
+
+	// the first request trips the flaky endpoint and is retried on the other
+	_, err = c.FindNearest(context.Background(), &models.Query{})
+	assert.Nil(t, err)
+	assert.Equal(t, StateOpen, c.Endpoints()[flaky.URL])
+
+	// after the cool-down the flaky endpoint is probed while the other one
+	// is healthy and serves traffic again
+	atomic.StoreInt32(&down, 0)
+	time.Sleep(30 * time.Millisecond)
+	for i := 0; i < 4; i++ {
+		_, err := c.FindNearest(context.Background(), &models.Query{})
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, StateClosed, c.Endpoints()[flaky.URL])
+	assert.Equal(t, int64(3), atomic.LoadInt64(flakyCount))
+}
+
+func Test_FindNearest_AllOpen(t *testing.T) {
+	down, _ := countingServer(t, nil)
+	down.Close()
+
+	c, err := New(Config{URLs: []string{down.URL}, Breaker: BreakerConfig{FailureThreshold: 1}})
+	assert.Nil(t, err)
+
+	_, err = c.FindNearest(context.Background(), &models.Query{})
+	assert.NotNil(t, err)
+
+	_, err = c.FindNearest(context.Background(), &models.Query{})
+	assert.Equal(t, ErrServiceUnreachable, err)
+	assert.Equal(t, map[string]State{down.URL: StateOpen}, c.Endpoints())
+}
+
+func Test_FindNearest_Retries(t *testing.T) {
+	var failures int64 = 2
+	server, count := countingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&failures, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	c, err := New(Config{URLs: []string{server.URL}, Retry: RetryConfig{MaxRetries: 2, BaseBackoff: time.Millisecond}})
+	assert.Nil(t, err)
+
+	before := readCounts()
+	response, err := c.FindNearest(context.Background(), &models.Query{})
+	assert.Nil(t, err)
+	assert.Len(t, response.Locations, 1)
+	assert.Equal(t, int64(3), atomic.LoadInt64(count))
+	assert.Equal(t, counts{requests: 1, retries: 2}, readCounts().sub(before))
+}
+
+func Test_FindNearest_RetriesExhausted(t *testing.T) {
+	server, count := countingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	c, err := New(Config{URLs: []string{server.URL}, Retry: RetryConfig{MaxRetries: 1, BaseBackoff: time.Millisecond}})
+	assert.Nil(t, err)
+
+	before := readCounts()
+	_, err = c.FindNearest(context.Background(), &models.Query{})
+	assert.True(t, errors.Is(err, ErrUnavailable))
+	assert.Equal(t, int64(2), atomic.LoadInt64(count))
+	assert.Equal(t, counts{requests: 1, retries: 1, failures: 1}, readCounts().sub(before))
+}
+
+func Test_FindNearest_RetriesHonorDeadline(t *testing.T) {
+	server, count := countingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	c, err := New(Config{URLs: []string{server.URL}, Retry: RetryConfig{MaxRetries: 5, BaseBackoff: time.Second, MaxBackoff: time.Second}})
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// no retry fits in the deadline, unless the jittered backoff is tiny
+	start := time.Now()
+	c.FindNearest(ctx, &models.Query{})
+	assert.Less(t, time.Since(start), 200*time.Millisecond)
+	assert.LessOrEqual(t, atomic.LoadInt64(count), int64(2))
+}
+
+func Test_FindNearest_Hedge(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	slow, _ := countingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	fast, fastCount := countingServer(t, nil)
+
+	c, err := New(Config{URLs: []string{slow.URL, fast.URL}, Hedge: true})
+	assert.Nil(t, err)
+
+	// requests are hedged after minLatencySamples latencies
+	client := c.(*httpClient)
+	for i := 0; i < minLatencySamples; i++ {
+		client.latencies.add(time.Millisecond)
+	}
+
+	// the first request goes to the slow endpoint and is hedged to the fast one
+	before := readCounts()
+	response, err := c.FindNearest(context.Background(), &models.Query{})
+	assert.Nil(t, err)
+	assert.Len(t, response.Locations, 1)
+	assert.Equal(t, int64(1), atomic.LoadInt64(fastCount))
+	assert.Equal(t, counts{requests: 1, hedges: 1, hedgeWins: 1}, readCounts().sub(before))
+}
+
+func Test_Backoff(t *testing.T) {
+	r := RetryConfig{BaseBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	for retry := uint(0); retry < 64; retry++ {
+		backoff := r.backoff(retry)
+		assert.Greater(t, backoff, time.Duration(0))
+		assert.LessOrEqual(t, backoff, 50*time.Millisecond)
+		if retry == 0 {
+			assert.LessOrEqual(t, backoff, 10*time.Millisecond)
+		}
+	}
+}
+
+func Test_Latencies(t *testing.T) {
+	var l latencies
+	_, ok := l.quantile(hedgeQuantile)
+	assert.False(t, ok)
+
+	for i := 1; i <= 100; i++ {
+		l.add(time.Duration(i) * time.Millisecond)
+	}
+	p95, ok := l.quantile(hedgeQuantile)
+	assert.True(t, ok)
+	assert.Equal(t, 95*time.Millisecond, p95)
+}
+
+func Test_FindNearest_Decode(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		err    error
+		msg    string
+		// failures is the increase of the failures counter
+		failures float64
+	}{
+		{"not_found", http.StatusNotFound, `{"code":404,"msg":"Not Found"}`, ErrNotFound, "Not Found", 0},
+		{"invalid_query", http.StatusBadRequest, `{"code":400,"msg":"you must provide a valid longitude"}`, ErrInvalidQuery, "you must provide a valid longitude", 0},
+		{"unauthorized", http.StatusBadRequest, `{"code":401,"msg":"Unauthorized"}`, ErrUnauthorized, "Unauthorized", 0},
+		{"unavailable", http.StatusBadGateway, `<html>bad gateway</html>`, ErrUnavailable, "Bad Gateway", 1},
+		{"empty", http.StatusOK, `{"code":200,"data":{"total":0,"locations":[]}}`, ErrNotFound, "Not Found", 0},
+		{"no_data", http.StatusOK, `{"code":200}`, ErrUnexpectedResponse, "", 0},
+		{"schema_drift", http.StatusOK, `{"code":200,"data":{"locations":{}}}`, ErrUnexpectedResponse, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			c, err := New(Config{URLs: []string{server.URL}})
+			assert.Nil(t, err)
+
+			before := readCounts()
+			response, err := c.FindNearest(context.Background(), &models.Query{})
+			assert.Nil(t, response)
+			assert.True(t, errors.Is(err, tt.err), err)
+			assert.Equal(t, tt.failures, readCounts().sub(before).failures)
+
+			var responseErr *ResponseError
+			if tt.msg != "" && assert.True(t, errors.As(err, &responseErr)) {
+				assert.Equal(t, tt.msg, responseErr.Msg)
+			}
+		})
+	}
+}
+
+func Test_FindNearest_Locations(t *testing.T) {
+	server, _ := countingServer(t, nil)
+	c, err := New(Config{URLs: []string{server.URL}})
+	assert.Nil(t, err)
+
+	response, err := c.FindNearest(context.Background(), &models.Query{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, response.Total)
+	assert.Equal(t, "6219f72c61d60d9a30ff2072", response.Locations[0].ID.Hex())
+	assert.Equal(t, []interface{}{41.9, 29.1}, response.Locations[0].Location.Coordinates)
+	assert.Equal(t, 6.3, response.Locations[0].Distance)
+}
+
+func Test_FindNearest_Traceparent(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var traceparent atomic.Value
+	server, _ := countingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		traceparent.Store(r.Header.Get("traceparent"))
+	})
+	c, err := New(Config{URLs: []string{server.URL}})
+	assert.Nil(t, err)
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+	_, err = c.FindNearest(ctx, &models.Query{})
+	assert.Nil(t, err)
+	parent.End()
+
+	// parent, FindNearest and the HTTP request
+	spans := recorder.Ended()
+	assert.Len(t, spans, 3)
+	assert.Equal(t, "driverlocation.FindNearest", spans[1].Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), spans[1].Parent().SpanID())
+	assert.Equal(t, spans[1].SpanContext().SpanID(), spans[0].Parent().SpanID())
+
+	// the request continues the trace of the HTTP span
+	assert.Equal(t, "00-"+parent.SpanContext().TraceID().String()+"-"+spans[0].SpanContext().SpanID().String()+"-01", traceparent.Load())
+}
+
+func Test_FindNearest_RequestID(t *testing.T) {
+	var id atomic.Value
+	server, _ := countingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		id.Store(r.Header.Get(requestid.Header))
+	})
+	c, err := New(Config{URLs: []string{server.URL}})
+	assert.Nil(t, err)
+
+	_, err = c.FindNearest(requestid.NewContext(context.Background(), "req-1"), &models.Query{})
+	assert.Nil(t, err)
+	assert.Equal(t, "req-1", id.Load())
+
+	_, err = c.FindNearest(context.Background(), &models.Query{})
+	assert.Nil(t, err)
+	assert.Equal(t, "", id.Load())
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	circuitState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "driverlocation_circuit_state",
+		Help: "State of the circuits of driverlocation endpoints, 0 is closed, 1 is open and 2 is half-open.",
+	}, []string{"endpoint"})
+
+	circuitTrips = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "driverlocation_circuit_trips_total",
+		Help: "Number of times the circuits of driverlocation endpoints opened.",
+	}, []string{"endpoint"})
+
+	requestsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "driverlocation_client_requests_total",
+		Help: "Number of FindNearest calls of the driverlocation client.",
+	})
+
+	retries = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "driverlocation_client_retries_total",
+		Help: "Number of retried driverlocation requests.",
+	})
+
+	hedges = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "driverlocation_client_hedges_total",
+		Help: "Number of driverlocation requests hedged to a second endpoint.",
+	})
+
+	hedgeWins = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "driverlocation_client_hedge_wins_total",
+		Help: "Number of hedged driverlocation requests whose result was used.",
+	})
+
+	failures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "driverlocation_client_failures_total",
+		Help: "Number of FindNearest calls that failed with a transport error or a 5xx response after all attempts.",
+	})
+)
+
+// observeState records the state of the circuit of an endpoint
+func observeState(endpoint string, state State) {
+	circuitState.WithLabelValues(endpoint).Set(float64(state))
+	if state == StateOpen {
+		circuitTrips.WithLabelValues(endpoint).Inc()
+	}
+}
@@ -0,0 +1,23 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_observeState(t *testing.T) {
+	endpoint := "http://driverlocation-metrics:3001"
+	_, err := New(Config{URLs: []string{endpoint + "/"}})
+	assert.Nil(t, err)
+	assert.Equal(t, float64(StateClosed), testutil.ToFloat64(circuitState.WithLabelValues(endpoint)))
+
+	observeState(endpoint, StateOpen)
+	observeState(endpoint, StateHalfOpen)
+	assert.Equal(t, float64(StateHalfOpen), testutil.ToFloat64(circuitState.WithLabelValues(endpoint)))
+
+	observeState(endpoint, StateOpen)
+	assert.Equal(t, float64(StateOpen), testutil.ToFloat64(circuitState.WithLabelValues(endpoint)))
+	assert.Equal(t, 2.0, testutil.ToFloat64(circuitTrips.WithLabelValues(endpoint)))
+}
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/s3f4/locationmatcher/pkg/log"
+)
+
+// Retry defaults
+const (
+	defaultRetries     = 2
+	defaultBaseBackoff = 50 * time.Millisecond
+	defaultMaxBackoff  = time.Second
+)
+
+// Hedging starts once latencySamples has minLatencySamples, the hedge delay is
+// the hedgeQuantile of the latest latencyWindow latencies.
+const (
+	latencyWindow     = 256
+	minLatencySamples = 20
+	hedgeQuantile     = 0.95
+)
+
+// RetryConfig configures retries of failed requests, requests are retried on
+// errors, open circuits and 429 and 5xx responses.
+type RetryConfig struct {
+	// MaxRetries is the number of retries after the first attempt, 0
+	// disables retries.
+	MaxRetries uint
+	// BaseBackoff is the backoff before the first retry, it doubles on every
+	// retry up to MaxBackoff and is jittered. It defaults to 50ms.
+	BaseBackoff time.Duration
+	// MaxBackoff defaults to one second
+	MaxBackoff time.Duration
+}
+
+// retryConfigFromEnv reads DRIVER_LOCATION_RETRIES and
+// DRIVER_LOCATION_RETRY_BACKOFF, invalid values are replaced by the defaults.
+func retryConfigFromEnv() RetryConfig {
+	config := RetryConfig{MaxRetries: defaultRetries}
+
+	if value := os.Getenv("DRIVER_LOCATION_RETRIES"); value != "" {
+		retries, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			log.Warnf("invalid DRIVER_LOCATION_RETRIES %q, %d retries are used", value, defaultRetries)
+		} else {
+			config.MaxRetries = uint(retries)
+		}
+	}
+
+	if value := os.Getenv("DRIVER_LOCATION_RETRY_BACKOFF"); value != "" {
+		backoff, err := time.ParseDuration(value)
+		if err != nil || backoff <= 0 {
+			log.Warnf("invalid DRIVER_LOCATION_RETRY_BACKOFF %q, %s is used", value, defaultBaseBackoff)
+		} else {
+			config.BaseBackoff = backoff
+		}
+	}
+
+	return config
+}
+
+func (r RetryConfig) withDefaults() RetryConfig {
+	if r.BaseBackoff == 0 {
+		r.BaseBackoff = defaultBaseBackoff
+	}
+	if r.MaxBackoff == 0 {
+		r.MaxBackoff = defaultMaxBackoff
+	}
+	if r.MaxBackoff < r.BaseBackoff {
+		r.MaxBackoff = r.BaseBackoff
+	}
+	return r
+}
+
+// backoff returns a random backoff between zero and the exponential backoff
+// of retry, retries start at 0.
+func (r RetryConfig) backoff(retry uint) time.Duration {
+	backoff := r.MaxBackoff
+	if retry < 32 {
+		if exponential := r.BaseBackoff << retry; exponential > 0 && exponential < backoff {
+			backoff = exponential
+		}
+	}
+	return time.Duration(rand.Int63n(int64(backoff))) + 1
+}
+
+// wait waits for d unless ctx is done first or its deadline leaves no time
+// for another attempt after d.
+func wait(ctx context.Context, d time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= d {
+		return false
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// retryableStatus reports whether a response with status may succeed on
+// another attempt
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// latencies keeps the latest latencyWindow latencies of successful requests
+type latencies struct {
+	m       sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if len(l.samples) < latencyWindow {
+		l.samples = append(l.samples, d)
+		return
+	}
+	l.samples[l.next] = d
+	l.next = (l.next + 1) % latencyWindow
+}
+
+// quantile returns the q quantile of the latencies, it is false until there
+// are minLatencySamples latencies.
+func (l *latencies) quantile(q float64) (time.Duration, bool) {
+	l.m.Lock()
+	if len(l.samples) < minLatencySamples {
+		l.m.Unlock()
+		return 0, false
+	}
+	sorted := make([]time.Duration, len(l.samples))
+	copy(sorted, l.samples)
+	l.m.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(q*float64(len(sorted)-1))], true
+}
@@ -5,17 +5,33 @@ import (
 	"os"
 
 	"github.com/s3f4/locationmatcher/internal/matching/server"
+	"github.com/s3f4/locationmatcher/pkg/log"
+	"github.com/s3f4/locationmatcher/pkg/shutdown"
+	"github.com/s3f4/locationmatcher/pkg/tracing"
 )
 
 func main() {
-	ctx, cancel := context.WithCancel(context.Background())
+	// ctx is canceled on SIGINT and SIGTERM
+	ctx, cancel := shutdown.NotifyContext(context.Background())
 	defer cancel()
 
+	// exports spans if OTEL_TRACES_EXPORTER is set
+	shutdownTracing, err := tracing.Init(ctx, "matching")
+	if err != nil {
+		panic(err)
+	}
+
 	server, err := server.NewServer(os.Getenv("SERVER"))
 	if err != nil {
 		panic(err)
 	}
 
-	// Starts server
+	// Starts server, it returns after in-flight requests are drained
 	server.Start(ctx)
+
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), shutdown.Timeout)
+	defer flushCancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		log.Error(err)
+	}
 }
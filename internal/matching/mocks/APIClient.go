@@ -3,7 +3,7 @@
 package mocks
 
 import (
-	apihelper "github.com/s3f4/locationmatcher/pkg/apihelper"
+	client "github.com/s3f4/locationmatcher/internal/matching/client"
 
 	context "context"
 
@@ -17,22 +17,38 @@ type APIClient struct {
 	mock.Mock
 }
 
-// FindNearest provides a mock function with given fields: _a0, _a1, _a2
-func (_m *APIClient) FindNearest(_a0 context.Context, _a1 string, _a2 *models.Query) (*apihelper.Response, error) {
-	ret := _m.Called(_a0, _a1, _a2)
+// Endpoints provides a mock function with given fields:
+func (_m *APIClient) Endpoints() map[string]client.State {
+	ret := _m.Called()
 
-	var r0 *apihelper.Response
-	if rf, ok := ret.Get(0).(func(context.Context, string, *models.Query) *apihelper.Response); ok {
-		r0 = rf(_a0, _a1, _a2)
+	var r0 map[string]client.State
+	if rf, ok := ret.Get(0).(func() map[string]client.State); ok {
+		r0 = rf()
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(*apihelper.Response)
+			r0 = ret.Get(0).(map[string]client.State)
+		}
+	}
+
+	return r0
+}
+
+// FindNearest provides a mock function with given fields: _a0, _a1
+func (_m *APIClient) FindNearest(_a0 context.Context, _a1 *models.Query) (*models.LocationsResponse, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *models.LocationsResponse
+	if rf, ok := ret.Get(0).(func(context.Context, *models.Query) *models.LocationsResponse); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.LocationsResponse)
 		}
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context, string, *models.Query) error); ok {
-		r1 = rf(_a0, _a1, _a2)
+	if rf, ok := ret.Get(1).(func(context.Context, *models.Query) error); ok {
+		r1 = rf(_a0, _a1)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -1,12 +1,20 @@
 package models
 
 import (
+	"time"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// StatusAvailable is the status of drivers that can be matched
+const StatusAvailable = "available"
+
 // DriverLocation holds the driver's location data
 type DriverLocation struct {
-	ID       primitive.ObjectID `json:"_id" bson:"_id"`
-	Location Location           `json:"location" bson:"location"`
-	Distance float64            `json:"distance" bson:"-"`
+	ID        primitive.ObjectID `json:"_id" bson:"_id"`
+	DriverID  string             `json:"driver_id,omitempty" bson:"driver_id,omitempty"`
+	Status    string             `json:"status,omitempty" bson:"status,omitempty"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+	Location  Location           `json:"location" bson:"location"`
+	Distance  float64            `json:"distance" bson:"-"`
 }
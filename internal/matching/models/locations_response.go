@@ -0,0 +1,23 @@
+package models
+
+// DefaultLimit is the number of nearest driver locations matched when the
+// query has no limit
+const DefaultLimit = 10
+
+// LocationsResponse holds the nearest driver locations of a query
+type LocationsResponse struct {
+	Total     int               `json:"total"`
+	Locations []*DriverLocation `json:"locations"`
+}
+
+// Top returns the response with its first n driver locations
+func (l *LocationsResponse) Top(n int64) *LocationsResponse {
+	if n <= 0 || int64(len(l.Locations)) <= n {
+		return l
+	}
+
+	return &LocationsResponse{
+		Total:     int(n),
+		Locations: l.Locations[:n],
+	}
+}
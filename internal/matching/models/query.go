@@ -6,10 +6,20 @@ import (
 
 var ErrInvalidCoordinates = fmt.Errorf("invalid coordinates")
 
+// MaxLimit is the maximum number of driver locations returned by a query
+const MaxLimit = 1000
+
 type Query struct {
 	Location    Location `json:"location"`
 	MinDistance int64    `json:"minDistance"`
 	MaxDistance int64    `json:"maxDistance"`
+	// Limit is the number of nearest driver locations to return, 0 means no limit
+	Limit int64 `json:"limit,omitempty"`
+	// Offset is the number of nearest driver locations to skip
+	Offset int64 `json:"offset,omitempty"`
+	// Status filters driver locations by driver status, it is set by the
+	// matching service so that only available drivers are matched
+	Status string `json:"status,omitempty"`
 }
 
 func (q Query) Validate() error {
@@ -49,5 +59,13 @@ func (q Query) Validate() error {
 		return fmt.Errorf("maxDistance must be greater then 0 and minDistance")
 	}
 
+	if q.Limit < 0 || q.Limit > MaxLimit {
+		return fmt.Errorf("limit must be between 0 and %d", MaxLimit)
+	}
+
+	if q.Offset < 0 {
+		return fmt.Errorf("offset must not be negative")
+	}
+
 	return nil
 }
@@ -0,0 +1,107 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.19.4
+// source: matching.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// MatchingClient is the client API for Matching service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MatchingClient interface {
+	// FindNearest returns the nearest drivers within the given distance range.
+	FindNearest(ctx context.Context, in *FindNearestRequest, opts ...grpc.CallOption) (*FindNearestResponse, error)
+}
+
+type matchingClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMatchingClient(cc grpc.ClientConnInterface) MatchingClient {
+	return &matchingClient{cc}
+}
+
+func (c *matchingClient) FindNearest(ctx context.Context, in *FindNearestRequest, opts ...grpc.CallOption) (*FindNearestResponse, error) {
+	out := new(FindNearestResponse)
+	err := c.cc.Invoke(ctx, "/matching.v1.Matching/FindNearest", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MatchingServer is the server API for Matching service.
+// All implementations must embed UnimplementedMatchingServer
+// for forward compatibility
+type MatchingServer interface {
+	// FindNearest returns the nearest drivers within the given distance range.
+	FindNearest(context.Context, *FindNearestRequest) (*FindNearestResponse, error)
+	mustEmbedUnimplementedMatchingServer()
+}
+
+// UnimplementedMatchingServer must be embedded to have forward compatible implementations.
+type UnimplementedMatchingServer struct {
+}
+
+func (UnimplementedMatchingServer) FindNearest(context.Context, *FindNearestRequest) (*FindNearestResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FindNearest not implemented")
+}
+func (UnimplementedMatchingServer) mustEmbedUnimplementedMatchingServer() {}
+
+// UnsafeMatchingServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MatchingServer will
+// result in compilation errors.
+type UnsafeMatchingServer interface {
+	mustEmbedUnimplementedMatchingServer()
+}
+
+func RegisterMatchingServer(s grpc.ServiceRegistrar, srv MatchingServer) {
+	s.RegisterService(&Matching_ServiceDesc, srv)
+}
+
+func _Matching_FindNearest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FindNearestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MatchingServer).FindNearest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/matching.v1.Matching/FindNearest",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MatchingServer).FindNearest(ctx, req.(*FindNearestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Matching_ServiceDesc is the grpc.ServiceDesc for Matching service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Matching_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "matching.v1.Matching",
+	HandlerType: (*MatchingServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "FindNearest",
+			Handler:    _Matching_FindNearest_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "matching.proto",
+}
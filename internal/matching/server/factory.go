@@ -19,7 +19,9 @@ func NewServer(server string) (Server, error) {
 		}, nil
 
 	case "grpc":
-		return &grpcServer{}, nil
+		return &grpcServer{
+			client: client.NewAPIClient(),
+		}, nil
 
 	default:
 		return nil, fmt.Errorf("no such server %s", server)
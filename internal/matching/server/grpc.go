@@ -2,9 +2,158 @@ package server
 
 import (
 	"context"
+	"errors"
+	"net"
+	"os"
+
+	"github.com/s3f4/locationmatcher/internal/matching/client"
+	"github.com/s3f4/locationmatcher/internal/matching/models"
+	"github.com/s3f4/locationmatcher/internal/matching/pb"
+	"github.com/s3f4/locationmatcher/internal/matching/server/middlewares"
+	"github.com/s3f4/locationmatcher/pkg/log"
+	"github.com/s3f4/locationmatcher/pkg/shutdown"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-type grpcServer struct{}
+type grpcServer struct {
+	pb.UnimplementedMatchingServer
+	client client.APIClient
+}
+
+// Start starts grpc server
+func (g *grpcServer) Start(ctx context.Context) {
+	service := os.Getenv("SERVICE")
+	port := os.Getenv("PORT")
+
+	listener, err := net.Listen("tcp", port)
+	if err != nil {
+		log.Fatalf("%s gRPC server listen err: %s\n", service, err)
+	}
+
+	server := grpc.NewServer(grpc.UnaryInterceptor(middlewares.AuthInterceptor))
+	pb.RegisterMatchingServer(server, g)
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(pb.Matching_ServiceDesc.ServiceName, grpc_health_v1.HealthCheckResponse_SERVING)
+	grpc_health_v1.RegisterHealthServer(server, healthServer)
+
+	// service discovery for grpcurl and similar tools
+	reflection.Register(server)
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != grpc.ErrServerStopped {
+			log.Fatalf("%s gRPC server serve err: %s\n", service, err)
+		}
+	}()
+
+	log.Infof("%s gRPC server started on port %s...\n", service, port)
+	<-ctx.Done()
+	log.Infof("%s gRPC server is draining...\n", service)
+	shutdown.GRPC(server, healthServer)
+	log.Infof("%s gRPC server stopped. \n", service)
+}
+
+// FindNearest returns the nearest driver within the given query parameters
+func (g *grpcServer) FindNearest(ctx context.Context, req *pb.FindNearestRequest) (*pb.FindNearestResponse, error) {
+	query := queryFromProto(req)
+	if err := query.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	response, err := g.client.FindNearest(ctx, query)
+	if err != nil {
+		return nil, clientErrorStatus(ctx, err)
+	}
+
+	locations := response.Top(query.Limit).Locations
+	if len(locations) == 0 {
+		return nil, status.Error(codes.NotFound, "Not Found")
+	}
+
+	res := &pb.FindNearestResponse{}
+	for _, location := range locations {
+		driverLocation, err := driverLocationToProto(location)
+		if err != nil {
+			log.FromContext(ctx).Error(err)
+			return nil, status.Error(codes.Internal, "Internal Server Error")
+		}
+		res.DriverLocations = append(res.DriverLocations, driverLocation)
+	}
+	res.DriverLocation = res.DriverLocations[0]
+
+	return res, nil
+}
+
+// clientErrorStatus returns the status of a driverlocation error
+func clientErrorStatus(ctx context.Context, err error) error {
+	var responseErr *client.ResponseError
+	switch {
+	case errors.Is(err, client.ErrNotFound):
+		return status.Error(codes.NotFound, "Not Found")
+	case errors.Is(err, client.ErrInvalidQuery) && errors.As(err, &responseErr):
+		return status.Error(codes.InvalidArgument, responseErr.Msg)
+	case errors.Is(err, client.ErrUnavailable), errors.Is(err, client.ErrServiceUnreachable):
+		log.FromContext(ctx).Error(err)
+		return status.Error(codes.Unavailable, "Service Unavailable")
+	default:
+		log.FromContext(ctx).Error(err)
+		return status.Error(codes.Internal, "Internal Server Error")
+	}
+}
+
+// queryFromProto converts coordinates to []interface{} which is
+// what models.Query expects after json decoding.
+func queryFromProto(req *pb.FindNearestRequest) *models.Query {
+	coordinates := make([]interface{}, 0, len(req.GetLocation().GetCoordinates()))
+	for _, coordinate := range req.GetLocation().GetCoordinates() {
+		coordinates = append(coordinates, coordinate)
+	}
+
+	query := &models.Query{
+		Location: models.Location{
+			Type:        req.GetLocation().GetType(),
+			Coordinates: coordinates,
+		},
+		MinDistance: req.GetMinDistance(),
+		MaxDistance: req.GetMaxDistance(),
+		Limit:       req.GetLimit(),
+		Offset:      req.GetOffset(),
+	}
+	matchQuery(query)
+
+	return query
+}
+
+func driverLocationToProto(driverLocation *models.DriverLocation) (*pb.DriverLocation, error) {
+	coords, ok := driverLocation.Location.Coordinates.([]interface{})
+	if !ok || len(coords) != 2 {
+		return nil, models.ErrInvalidCoordinates
+	}
+
+	coordinates := make([]float64, 0, len(coords))
+	for _, coord := range coords {
+		coordinate, ok := coord.(float64)
+		if !ok {
+			return nil, models.ErrInvalidCoordinates
+		}
+		coordinates = append(coordinates, coordinate)
+	}
 
-func (*grpcServer) Start(ctx context.Context) {
+	return &pb.DriverLocation{
+		Id: driverLocation.ID.Hex(),
+		Location: &pb.Location{
+			Type:        driverLocation.Location.Type,
+			Coordinates: coordinates,
+		},
+		Distance:  driverLocation.Distance,
+		DriverId:  driverLocation.DriverID,
+		Status:    string(driverLocation.Status),
+		UpdatedAt: timestamppb.New(driverLocation.UpdatedAt),
+	}, nil
 }
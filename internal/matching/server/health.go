@@ -0,0 +1,39 @@
+package server
+
+import (
+	"context"
+
+	"github.com/s3f4/locationmatcher/internal/matching/client"
+	"github.com/s3f4/locationmatcher/pkg/health"
+	"github.com/s3f4/locationmatcher/pkg/shutdown"
+)
+
+// readyChecks checks the server is not draining and driverlocation can be
+// reached
+func readyChecks(readiness *shutdown.Readiness, apiClient client.APIClient) health.Checks {
+	return health.Checks{
+		"server":         health.Serving(readiness),
+		"driverlocation": driverLocationCheck(apiClient),
+	}
+}
+
+// driverLocationCheck is up while the circuit of at least one driverlocation
+// endpoint is not open, the state of every endpoint is in the details.
+func driverLocationCheck(apiClient client.APIClient) health.Check {
+	return func(context.Context) health.Component {
+		component := health.Component{
+			Status:  health.StatusDown,
+			Error:   "the circuits of all driverlocation endpoints are open",
+			Details: map[string]string{},
+		}
+
+		for url, state := range apiClient.Endpoints() {
+			component.Details[url] = state.String()
+			if state != client.StateOpen {
+				component.Status, component.Error = health.StatusUp, ""
+			}
+		}
+
+		return component
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"os"
 	"time"
@@ -11,12 +12,20 @@ import (
 	"github.com/s3f4/locationmatcher/internal/matching/client"
 	"github.com/s3f4/locationmatcher/internal/matching/models"
 	"github.com/s3f4/locationmatcher/internal/matching/server/middlewares"
+	"github.com/s3f4/locationmatcher/pkg/accesslog"
 	"github.com/s3f4/locationmatcher/pkg/apihelper"
+	"github.com/s3f4/locationmatcher/pkg/auth"
+	"github.com/s3f4/locationmatcher/pkg/health"
 	"github.com/s3f4/locationmatcher/pkg/log"
+	"github.com/s3f4/locationmatcher/pkg/metrics"
+	"github.com/s3f4/locationmatcher/pkg/requestid"
+	"github.com/s3f4/locationmatcher/pkg/shutdown"
+	"github.com/s3f4/locationmatcher/pkg/tracing"
 )
 
 type httpServer struct {
-	client client.APIClient
+	client    client.APIClient
+	readiness shutdown.Readiness
 }
 
 func (h *httpServer) Start(ctx context.Context) {
@@ -25,11 +34,25 @@ func (h *httpServer) Start(ctx context.Context) {
 
 	var router *chi.Mux = chi.NewRouter()
 
+	router.Use(requestid.Middleware, tracing.Middleware, metrics.Middleware, accesslog.Middleware)
+
+	// probes of the orchestrator and scrapes of prometheus are not authenticated
+	router.Handle("/metrics", metrics.Handler())
+	router.Get("/healthz", health.Live)
+	router.Get("/readyz", health.Ready(readyChecks(&h.readiness, h.client)))
+
 	router.Route("/api/v1", func(router chi.Router) {
 		router.Use(middlewares.AuthCtx)
 		router.Post("/find_nearest", h.FindNearest)
 	})
 
+	// the log level is changed at runtime by admins
+	router.Route("/admin", func(router chi.Router) {
+		router.Use(middlewares.AuthCtx, auth.RequireScope(auth.ScopeAdmin))
+		router.Method(http.MethodGet, "/log/level", log.LevelHandler())
+		router.Method(http.MethodPut, "/log/level", log.LevelHandler())
+	})
+
 	// documentation for developers
 	opts := middleware.SwaggerUIOpts{
 		SpecURL: "/static/swagger.yaml",
@@ -60,19 +83,28 @@ func (h *httpServer) Start(ctx context.Context) {
 
 	log.Infof("%s HTTP server started on port %s...\n", service, port)
 	<-ctx.Done()
+	log.Infof("%s HTTP server is draining...\n", service)
+	if err := shutdown.HTTP(server, &h.readiness); err != nil {
+		log.Errorf("%s HTTP server shutdown err: %s\n", service, err)
+	}
 	log.Infof("%s HTTP server stopped. \n", service)
 }
 
 // swagger:route POST /find_nearest Find
-// returns nearest locations within the given query parameters
+// returns the nearest locations within the given query parameters, up to
+// the limit of the query
 //
 // security:
 // - Bearer: []
 // responses:
+//  400: ApiError
 //  401: ApiError
-//  200: DriverLocation
+//  404: ApiError
+//  500: ApiError
+//  503: ApiError
+//  200: LocationsResponse
 func (h *httpServer) FindNearest(w http.ResponseWriter, r *http.Request) {
-	context := r.Context()
+	ctx := r.Context()
 	var query models.Query
 	if err := apihelper.ParseAndValidate(r, &query); err != nil {
 		apihelper.SendResponse(w, err.Code, apihelper.Response{
@@ -82,23 +114,33 @@ func (h *httpServer) FindNearest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response, err := h.client.FindNearest(context, "http://driverlocation:3001/api/v1/driver_locations/find_nearest", &query)
+	matchQuery(&query)
+
+	response, err := h.client.FindNearest(ctx, &query)
 	if err != nil {
-		log.Error(err)
-		apihelper.Send500(w)
+		sendClientError(ctx, w, err)
 		return
 	}
 
-	if response.Code == http.StatusNotFound {
-		apihelper.Send404(w)
-		return
-	}
+	apihelper.SendResponse(w, http.StatusOK, response.Top(query.Limit))
+}
 
-	locationsData, ok := response.Data.(map[string]interface{})["locations"].([]interface{})
-	if !ok {
-		apihelper.Send400(w)
-		return
+// sendClientError sends the response of a driverlocation error
+func sendClientError(ctx context.Context, w http.ResponseWriter, err error) {
+	var responseErr *client.ResponseError
+	switch {
+	case errors.Is(err, client.ErrNotFound):
+		apihelper.Send404(w)
+	case errors.Is(err, client.ErrInvalidQuery) && errors.As(err, &responseErr):
+		apihelper.SendResponse(w, http.StatusBadRequest, apihelper.Response{
+			Code: http.StatusBadRequest,
+			Msg:  responseErr.Msg,
+		})
+	case errors.Is(err, client.ErrUnavailable), errors.Is(err, client.ErrServiceUnreachable):
+		log.FromContext(ctx).Error(err)
+		apihelper.Send503(w)
+	default:
+		log.FromContext(ctx).Error(err)
+		apihelper.Send500(w)
 	}
-
-	apihelper.SendResponse(w, 200, locationsData[0])
 }
@@ -8,10 +8,13 @@ import (
 	"strings"
 	"testing"
 
+	apiclient "github.com/s3f4/locationmatcher/internal/matching/client"
 	"github.com/s3f4/locationmatcher/internal/matching/mocks"
-	"github.com/s3f4/locationmatcher/pkg/apihelper"
+	"github.com/s3f4/locationmatcher/internal/matching/models"
+	"github.com/s3f4/locationmatcher/pkg/health"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type testParams struct {
@@ -41,7 +44,32 @@ var FindDataNotFound = []testParams{
 }
 
 var FindDataSuccess = []testParams{
-	{"find_nearest_success_success", http.MethodPost, "/api/v1/driver_location/find_nearest", `{"latitude":90,"longitude":55,"minDistance": 0,"maxDistance": 10000}`, 200, `{"code":200,"data":{"locations":[{"_id":"000000000000000000000000","location":{"type":"","coordinates":null},"distance":0,"mongo_distance":0}],"total":1}}`},
+	{"find_nearest_success_success", http.MethodPost, "/api/v1/driver_location/find_nearest", `{"location": {"type": "Point","coordinates": [41.90513187,29.15188821]},"minDistance": 55,"maxDistance": 10000,"limit":2}`, 200, `{"total":2,"locations":[{"_id":"000000000000000000000001","updated_at":"0001-01-01T00:00:00Z","location":{"type":"Point","coordinates":[41.9,29.1]},"distance":1},{"_id":"000000000000000000000002","updated_at":"0001-01-01T00:00:00Z","location":{"type":"Point","coordinates":[41.9,29.1]},"distance":2}]}`},
+}
+
+var FindDataClientError = []struct {
+	testParams
+	err error
+}{
+	{testParams{"find_nearest_invalid_query", http.MethodPost, "/api/v1/driver_location/find_nearest", `{"location": {"type": "Point","coordinates": [41.90513187,29.15188821]},"minDistance": 55,"maxDistance": 10000}`, 400, `{"code":400,"msg":"limit must not be greater than 1000"}`}, &apiclient.ResponseError{Code: 400, Msg: "limit must not be greater than 1000", Err: apiclient.ErrInvalidQuery}},
+	{testParams{"find_nearest_unavailable", http.MethodPost, "/api/v1/driver_location/find_nearest", `{"location": {"type": "Point","coordinates": [41.90513187,29.15188821]},"minDistance": 55,"maxDistance": 10000}`, 503, `{"code":503,"msg":"Service Unavailable"}`}, &apiclient.ResponseError{Code: 503, Msg: "Service Unavailable", Err: apiclient.ErrUnavailable}},
+	{testParams{"find_nearest_unreachable", http.MethodPost, "/api/v1/driver_location/find_nearest", `{"location": {"type": "Point","coordinates": [41.90513187,29.15188821]},"minDistance": 55,"maxDistance": 10000}`, 503, `{"code":503,"msg":"Service Unavailable"}`}, apiclient.ErrServiceUnreachable},
+	{testParams{"find_nearest_unauthorized", http.MethodPost, "/api/v1/driver_location/find_nearest", `{"location": {"type": "Point","coordinates": [41.90513187,29.15188821]},"minDistance": 55,"maxDistance": 10000}`, 500, `{"code":500,"msg":"Internal Server Error"}`}, &apiclient.ResponseError{Code: 401, Msg: "Unauthorized", Err: apiclient.ErrUnauthorized}},
+}
+
+// driverLocations returns n driver locations ordered by distance
+func driverLocations(n int) *models.LocationsResponse {
+	response := &models.LocationsResponse{Total: n}
+	for i := 1; i <= n; i++ {
+		var id primitive.ObjectID
+		id[11] = byte(i)
+		response.Locations = append(response.Locations, &models.DriverLocation{
+			ID:       id,
+			Location: models.Location{Type: "Point", Coordinates: []interface{}{41.9, 29.1}},
+			Distance: float64(i),
+		})
+	}
+	return response
 }
 
 func Test_Find_Param(t *testing.T) {
@@ -68,7 +96,7 @@ func Test_Find(t *testing.T) {
 	for _, data := range FindData {
 		t.Run(data.name, func(t *testing.T) {
 			client := new(mocks.APIClient)
-			client.On("FindNearest", mock.Anything, mock.Anything, mock.Anything).Return(nil, fmt.Errorf("err"))
+			client.On("FindNearest", mock.Anything, mock.Anything).Return(nil, fmt.Errorf("err"))
 			server := &httpServer{client: client}
 			w := httptest.NewRecorder()
 			req := httptest.NewRequest(data.method, data.url, strings.NewReader(data.body))
@@ -90,7 +118,54 @@ func Test_Find_NotFound(t *testing.T) {
 	for _, data := range FindDataNotFound {
 		t.Run(data.name, func(t *testing.T) {
 			client := new(mocks.APIClient)
-			client.On("FindNearest", mock.Anything, mock.Anything, mock.Anything).Return(&apihelper.Response{Code: 404, Msg: "Not Found"}, nil)
+			client.On("FindNearest", mock.Anything, mock.Anything).Return(nil, &apiclient.ResponseError{Code: 404, Msg: "Not Found", Err: apiclient.ErrNotFound})
+			server := &httpServer{client: client}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(data.method, data.url, strings.NewReader(data.body))
+			server.FindNearest(w, req)
+
+			res := w.Result()
+			body, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Error(err)
+			}
+
+			assert.Equal(t, data.expectedBody, string(body))
+			assert.Equal(t, data.expectedCode, w.Code)
+		})
+	}
+}
+
+func Test_Find_ClientError(t *testing.T) {
+	for _, data := range FindDataClientError {
+		t.Run(data.name, func(t *testing.T) {
+			client := new(mocks.APIClient)
+			client.On("FindNearest", mock.Anything, mock.Anything).Return(nil, data.err)
+			server := &httpServer{client: client}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(data.method, data.url, strings.NewReader(data.body))
+			server.FindNearest(w, req)
+
+			res := w.Result()
+			body, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Error(err)
+			}
+
+			assert.Equal(t, data.expectedBody, string(body))
+			assert.Equal(t, data.expectedCode, w.Code)
+		})
+	}
+}
+
+func Test_Find_Success(t *testing.T) {
+	for _, data := range FindDataSuccess {
+		t.Run(data.name, func(t *testing.T) {
+			client := new(mocks.APIClient)
+			// driverlocation returns more locations than the limit
+			client.On("FindNearest", mock.Anything, mock.MatchedBy(func(query *models.Query) bool {
+				return query.Limit == 2 && query.Status == models.StatusAvailable
+			})).Return(driverLocations(3), nil)
 			server := &httpServer{client: client}
 			w := httptest.NewRecorder()
 			req := httptest.NewRequest(data.method, data.url, strings.NewReader(data.body))
@@ -107,3 +182,40 @@ func Test_Find_NotFound(t *testing.T) {
 		})
 	}
 }
+
+func Test_Find_DefaultLimit(t *testing.T) {
+	client := new(mocks.APIClient)
+	client.On("FindNearest", mock.Anything, mock.MatchedBy(func(query *models.Query) bool {
+		return query.Limit == models.DefaultLimit
+	})).Return(driverLocations(1), nil)
+	server := &httpServer{client: client}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/driver_location/find_nearest", strings.NewReader(`{"location": {"type": "Point","coordinates": [41.90513187,29.15188821]},"minDistance": 55,"maxDistance": 10000}`))
+	server.FindNearest(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	client.AssertExpectations(t)
+}
+
+func Test_Ready(t *testing.T) {
+	client := new(mocks.APIClient)
+	client.On("Endpoints").Return(map[string]apiclient.State{
+		"http://driverlocation-1:3001": apiclient.StateOpen,
+		"http://driverlocation-2:3001": apiclient.StateHalfOpen,
+	}).Once()
+	client.On("Endpoints").Return(map[string]apiclient.State{
+		"http://driverlocation-1:3001": apiclient.StateOpen,
+	}).Once()
+	server := &httpServer{client: client}
+	ready := health.Ready(readyChecks(&server.readiness, client))
+
+	w := httptest.NewRecorder()
+	ready(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"status":"up","components":{"driverlocation":{"status":"up","details":{"http://driverlocation-1:3001":"open","http://driverlocation-2:3001":"half-open"}},"server":{"status":"up"}}}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	ready(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, `{"status":"down","components":{"driverlocation":{"status":"down","error":"the circuits of all driverlocation endpoints are open","details":{"http://driverlocation-1:3001":"open"}},"server":{"status":"up"}}}`, w.Body.String())
+}
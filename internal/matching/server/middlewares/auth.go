@@ -1,42 +1,50 @@
 package middlewares
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/s3f4/locationmatcher/pkg/apihelper"
+	"github.com/s3f4/locationmatcher/pkg/auth"
 )
 
-// AuthCtx gets user from token
+// verifier verifies the tokens of AuthCtx and AuthInterceptor, it is built
+// from the environment and can be replaced by SetVerifier.
+var verifier = auth.VerifierFromEnv()
+
+// SetVerifier replaces the verifier of AuthCtx and AuthInterceptor
+func SetVerifier(v *auth.Verifier) {
+	verifier = v
+}
+
+// AuthCtx verifies the bearer token and puts its claims into the request context
 func AuthCtx(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := tokenFromHeader(r)
-		if err := verifyToken(token); err != nil {
+		claims, err := verifyToken(r.Context(), tokenFromHeader(r))
+		if err != nil {
 			apihelper.Send401(w)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(auth.WithClaims(r.Context(), claims)))
 	})
 }
 
-func verifyToken(tokenStr string) error {
-	token, _, err := new(jwt.Parser).ParseUnverified(tokenStr, jwt.MapClaims{})
+// verifyToken verifies tokenStr and requires the authenticated claim
+func verifyToken(ctx context.Context, tokenStr string) (jwt.MapClaims, error) {
+	claims, err := verifier.Verify(ctx, tokenStr)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok {
-		if claims["authenticated"] == true {
-			return nil
-		} else {
-			return fmt.Errorf("unauthorized")
-		}
+	if claims["authenticated"] != true {
+		return nil, fmt.Errorf("unauthorized")
 	}
 
-	return nil
+	return claims, nil
 }
 
 // tokenFromHeader ...
@@ -4,9 +4,61 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/s3f4/locationmatcher/pkg/auth"
+	"github.com/stretchr/testify/assert"
 )
 
+// testSecret signs the hardcoded tokens of the tests
+const testSecret = "your-256-bit-secret"
+
+func TestMain(m *testing.M) {
+	v, err := auth.NewVerifier(auth.VerifierConfig{HMACSecrets: []string{testSecret}})
+	if err != nil {
+		panic(err)
+	}
+	SetVerifier(v)
+
+	os.Exit(m.Run())
+}
+
+func sign(t *testing.T, secret string, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	assert.Nil(t, err)
+	return token
+}
+
+func Test_AuthCtx_Claims(t *testing.T) {
+	var claims jwt.MapClaims
+	authHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ = auth.ClaimsFromContext(r.Context())
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://domain.com", nil)
+	req.Header.Set("Authorization", "Bearer "+sign(t, testSecret, jwt.MapClaims{
+		"authenticated": true,
+		"sub":           "dispatch",
+	}))
+
+	w := httptest.NewRecorder()
+	AuthCtx(authHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "dispatch", claims["sub"])
+}
+
+func Test_AuthCtx_Unsigned(t *testing.T) {
+	authHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	req := httptest.NewRequest(http.MethodGet, "http://domain.com", nil)
+	req.Header.Set("Authorization", "Bearer "+sign(t, "minted", jwt.MapClaims{"authenticated": true}))
+
+	w := httptest.NewRecorder()
+	AuthCtx(authHandler).ServeHTTP(w, req)
+	assert.Equal(t, `{"code":401,"msg":"Unauthorized"}`, w.Body.String())
+}
+
 func TestAuth(t *testing.T) {
 	authHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
 	req := httptest.NewRequest(http.MethodGet, "http://domain.com", nil)
@@ -0,0 +1,42 @@
+package middlewares
+
+import (
+	"context"
+	"strings"
+
+	"github.com/s3f4/locationmatcher/pkg/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// AuthInterceptor is the gRPC equivalent of AuthCtx, it verifies the
+// bearer token in the authorization metadata of the incoming request.
+func AuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	claims, err := verifyToken(ctx, tokenFromMetadata(ctx))
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Unauthorized")
+	}
+
+	return handler(auth.WithClaims(ctx, claims), req)
+}
+
+// tokenFromMetadata ...
+func tokenFromMetadata(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return ""
+	}
+
+	bearer := values[0]
+	if len(bearer) > 7 && strings.ToUpper(bearer[0:6]) == "BEARER" {
+		return bearer[7:]
+	}
+	return ""
+}
@@ -0,0 +1,12 @@
+package server
+
+import "github.com/s3f4/locationmatcher/internal/matching/models"
+
+// matchQuery limits query to available drivers, queries without a limit
+// match up to models.DefaultLimit drivers.
+func matchQuery(query *models.Query) {
+	query.Status = models.StatusAvailable
+	if query.Limit == 0 {
+		query.Limit = models.DefaultLimit
+	}
+}
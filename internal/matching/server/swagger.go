@@ -15,6 +15,8 @@
 // swagger:meta
 package server
 
+import "time"
+
 type Location struct {
 	// example: Point
 	Type string `json:"type"`
@@ -39,6 +41,15 @@ type DriverLocation struct {
 	// Id of the driver location
 	// in: string
 	ID string `json:"_id"`
+	// Id of the driver
+	// in: string
+	DriverID string `json:"driver_id"`
+	// Status of the driver, empty is stored as available
+	// enum: available,busy,offline
+	Status string `json:"status"`
+	// Time of the last upsert
+	// readonly: true
+	UpdatedAt time.Time `json:"updated_at"`
 	// Id of the driver location
 	// in: Location
 	Location Location `json:"location"`
@@ -50,6 +61,15 @@ type DriverLocation struct {
 	MongoDistance *float64 `json:"mongo_distance"`
 }
 
+// swagger:model LocationsResponse
+type LocationsResponse struct {
+	// Number of the driver locations
+	// in: int
+	Total int `json:"total"`
+	// Nearest driver locations ordered by distance
+	Locations []*DriverLocation `json:"locations"`
+}
+
 type Query struct {
 	// Id of the driver location
 	// in: Location
@@ -61,6 +81,14 @@ type Query struct {
 	// in: float64
 	// example: 10000
 	MaxDistance int64 `json:"maxDistance"`
+	// Maximum number of nearest locations, 0 returns 10
+	// in: int64
+	// maximum: 1000
+	// example: 10
+	Limit int64 `json:"limit"`
+	// Number of nearest locations to skip
+	// in: int64
+	Offset int64 `json:"offset"`
 }
 
 // swagger:parameters v1 Find
@@ -0,0 +1,191 @@
+package accesslog
+
+import (
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/s3f4/locationmatcher/pkg/log"
+	"github.com/s3f4/locationmatcher/pkg/signature"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// redacted replaces the values of RedactedHeaders
+const redacted = "[REDACTED]"
+
+var (
+	// SampleRate is the fraction of successful requests that are logged,
+	// failed requests are always logged. It is read from
+	// ACCESS_LOG_SAMPLE_RATE.
+	SampleRate = parseSampleRate(os.Getenv("ACCESS_LOG_SAMPLE_RATE"))
+
+	// TrustedProxies are the proxies whose X-Forwarded-For and X-Real-IP
+	// headers are used for the client ip, it is read from
+	// ACCESS_LOG_TRUSTED_PROXIES, comma separated ips or CIDRs.
+	TrustedProxies = parseTrustedProxies(os.Getenv("ACCESS_LOG_TRUSTED_PROXIES"))
+
+	// RedactedHeaders are logged without their values
+	RedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", signature.HeaderSignature}
+)
+
+// parseSampleRate parses a rate between 0 and 1, invalid values log every
+// request
+func parseSampleRate(value string) float64 {
+	if value == "" {
+		return 1
+	}
+
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		log.Warnf("invalid ACCESS_LOG_SAMPLE_RATE %q, every request is logged", value)
+		return 1
+	}
+
+	return rate
+}
+
+// parseTrustedProxies parses comma separated ips or CIDRs, invalid ones are
+// skipped
+func parseTrustedProxies(value string) []*net.IPNet {
+	var proxies []*net.IPNet
+	for _, proxy := range strings.Split(value, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy == "" {
+			continue
+		}
+
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				log.Warnf("invalid ACCESS_LOG_TRUSTED_PROXIES entry %q is skipped", proxy)
+				continue
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			log.Warnf("invalid ACCESS_LOG_TRUSTED_PROXIES entry %q is skipped", proxy)
+			continue
+		}
+		proxies = append(proxies, network)
+	}
+
+	return proxies
+}
+
+// Middleware logs the method, route, status, size, latency, client ip and
+// headers of requests. It must be used after requestid.Middleware, whose
+// logger carries the request id and the route pattern. Entries are written
+// by the access logger, which is not sampled.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		if status < http.StatusBadRequest && !sampled(SampleRate) {
+			return
+		}
+
+		fields := []interface{}{
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Int("status", status),
+			zap.Int("bytes", ww.BytesWritten()),
+			zap.Duration("latency", time.Since(start)),
+			zap.String("client_ip", clientIP(r)),
+			zap.Object("headers", headers(r.Header)),
+		}
+
+		logger := log.AccessFromContext(r.Context())
+		if status >= http.StatusInternalServerError {
+			logger.Warnw("request", fields...)
+			return
+		}
+		logger.Infow("request", fields...)
+	})
+}
+
+// sampled reports whether a request is logged with rate
+func sampled(rate float64) bool {
+	return rate >= 1 || rand.Float64() < rate
+}
+
+// clientIP returns the remote address of r. Requests of TrustedProxies are
+// logged with the last address of X-Forwarded-For that is not a trusted
+// proxy, or with X-Real-IP.
+func clientIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+
+	if !trusted(remote) {
+		return remote
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		addresses := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(addresses) - 1; i >= 0; i-- {
+			address := strings.TrimSpace(addresses[i])
+			if i == 0 || !trusted(address) {
+				return address
+			}
+		}
+	}
+
+	if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+		return realIP
+	}
+
+	return remote
+}
+
+// trusted reports whether address is one of TrustedProxies
+func trusted(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, proxy := range TrustedProxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// headers encodes request headers with the values of RedactedHeaders
+// replaced
+type headers http.Header
+
+func (h headers) MarshalLogObject(encoder zapcore.ObjectEncoder) error {
+	for key, values := range h {
+		value := strings.Join(values, ",")
+		for _, header := range RedactedHeaders {
+			if http.CanonicalHeaderKey(header) == key {
+				value = redacted
+				break
+			}
+		}
+		encoder.AddString(key, value)
+	}
+	return nil
+}
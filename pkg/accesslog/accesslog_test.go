@@ -0,0 +1,132 @@
+package accesslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/s3f4/locationmatcher/pkg/log"
+	"github.com/s3f4/locationmatcher/pkg/requestid"
+	"github.com/stretchr/testify/assert"
+)
+
+// entries returns the json entries of a log file
+func entries(t *testing.T, path string) []map[string]interface{} {
+	file, err := os.Open(path)
+	assert.Nil(t, err)
+	defer file.Close()
+
+	var entries []map[string]interface{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry map[string]interface{}
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func Test_Middleware(t *testing.T) {
+	defer func(rate float64) { SampleRate = rate }(SampleRate)
+	defer func(proxies []*net.IPNet) { TrustedProxies = proxies }(TrustedProxies)
+	defer log.Configure(log.DefaultConfig())
+	TrustedProxies = parseTrustedProxies("192.0.2.1, 10.0.0.2")
+
+	path := filepath.Join(t.TempDir(), "access.log")
+	config := log.DefaultConfig()
+	config.OutputPaths = []string{path}
+	assert.Nil(t, log.Configure(config))
+
+	router := chi.NewRouter()
+	router.Use(requestid.Middleware, Middleware)
+	router.Get("/drivers/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("driver"))
+	})
+	router.Get("/fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/drivers/1", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Proxy-Authorization", "Basic secret")
+	req.Header.Set("X-Forwarded-For", "10.0.0.1, 10.0.0.2")
+	req.Header.Set(requestid.Header, "req-1")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	// successful requests are sampled, failed ones are always logged
+	SampleRate = 0
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/drivers/2", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", nil))
+
+	logged := entries(t, path)
+	assert.Len(t, logged, 2)
+	assert.Equal(t, log.AccessLogger, logged[0]["logger"])
+
+	assert.Equal(t, "info", logged[0]["level"])
+	assert.Equal(t, "GET", logged[0]["method"])
+	assert.Equal(t, "/drivers/{id}", logged[0][log.RouteKey])
+	assert.Equal(t, "/drivers/1", logged[0]["path"])
+	assert.Equal(t, 200.0, logged[0]["status"])
+	assert.Equal(t, 6.0, logged[0]["bytes"])
+	assert.Contains(t, logged[0], "latency")
+	assert.Equal(t, "10.0.0.1", logged[0]["client_ip"])
+	assert.Equal(t, "req-1", logged[0][log.RequestIDKey])
+	assert.Equal(t, redacted, logged[0]["headers"].(map[string]interface{})["Authorization"])
+	assert.Equal(t, redacted, logged[0]["headers"].(map[string]interface{})["Proxy-Authorization"])
+	assert.Equal(t, "10.0.0.1, 10.0.0.2", logged[0]["headers"].(map[string]interface{})["X-Forwarded-For"])
+
+	assert.Equal(t, "warn", logged[1]["level"])
+	assert.Equal(t, "/fail", logged[1][log.RouteKey])
+	assert.Equal(t, 500.0, logged[1]["status"])
+
+	// failures are not dropped by the sampling of the logger
+	for i := 0; i < 300; i++ {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", nil))
+	}
+	assert.Equal(t, 302, len(entries(t, path)))
+}
+
+func Test_parseSampleRate(t *testing.T) {
+	assert.Equal(t, 1.0, parseSampleRate(""))
+	assert.Equal(t, 0.1, parseSampleRate("0.1"))
+	assert.Equal(t, 1.0, parseSampleRate("2"))
+	assert.Equal(t, 1.0, parseSampleRate("half"))
+}
+
+func Test_clientIP(t *testing.T) {
+	defer func(proxies []*net.IPNet) { TrustedProxies = proxies }(TrustedProxies)
+	TrustedProxies = parseTrustedProxies("192.168.1.0/24, 10.0.0.2")
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.168.1.5:52100"
+	assert.Equal(t, "192.168.1.5", clientIP(req))
+
+	req.Header.Set("X-Real-IP", "10.0.0.3")
+	assert.Equal(t, "10.0.0.3", clientIP(req))
+
+	// addresses added by trusted proxies are skipped
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1, 10.0.0.2")
+	assert.Equal(t, "10.0.0.1", clientIP(req))
+
+	req.Header.Set("X-Forwarded-For", "10.0.0.2")
+	assert.Equal(t, "10.0.0.2", clientIP(req))
+
+	// headers of other clients are not used
+	req.RemoteAddr = "203.0.113.9:52100"
+	assert.Equal(t, "203.0.113.9", clientIP(req))
+}
+
+func Test_parseTrustedProxies(t *testing.T) {
+	proxies := parseTrustedProxies("10.0.0.1, 172.16.0.0/12, ::1, proxy, 10.0.0.0/33")
+	assert.Len(t, proxies, 3)
+	assert.Equal(t, "10.0.0.1/32", proxies[0].String())
+	assert.Equal(t, "172.16.0.0/12", proxies[1].String())
+	assert.Equal(t, "::1/128", proxies[2].String())
+	assert.Nil(t, parseTrustedProxies(""))
+}
@@ -27,5 +27,7 @@ var (
 	Err401 = NewApiError(http.StatusUnauthorized, "Unauthorized")
 	Err403 = NewApiError(http.StatusForbidden, "Forbidden")
 	Err404 = NewApiError(http.StatusNotFound, "Not Found")
+	Err409 = NewApiError(http.StatusConflict, "Conflict")
 	Err500 = NewApiError(http.StatusInternalServerError, "Internal Server Error")
+	Err503 = NewApiError(http.StatusServiceUnavailable, "Service Unavailable")
 )
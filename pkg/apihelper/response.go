@@ -45,6 +45,16 @@ func Send401(w http.ResponseWriter) {
 		})
 }
 
+// Send403 sends forbidden error
+func Send403(w http.ResponseWriter) {
+	SendResponse(
+		w,
+		Err403.Code, Response{
+			Code: Err403.Code,
+			Msg:  Err403.Error(),
+		})
+}
+
 // Send404 sends not found error
 func Send404(w http.ResponseWriter) {
 	SendResponse(
@@ -55,6 +65,16 @@ func Send404(w http.ResponseWriter) {
 		})
 }
 
+// Send409 sends conflict error
+func Send409(w http.ResponseWriter) {
+	SendResponse(
+		w,
+		Err409.Code, Response{
+			Code: Err409.Code,
+			Msg:  Err409.Error(),
+		})
+}
+
 // Send500 sends internal server error
 func Send500(w http.ResponseWriter) {
 	SendResponse(
@@ -66,3 +86,15 @@ func Send500(w http.ResponseWriter) {
 		},
 	)
 }
+
+// Send503 sends service unavailable error
+func Send503(w http.ResponseWriter) {
+	SendResponse(
+		w,
+		Err503.Code,
+		Response{
+			Code: Err503.Code,
+			Msg:  Err503.Error(),
+		},
+	)
+}
@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrUnknownKey is returned when the kid of a token is not in the JWKS
+	ErrUnknownKey = errors.New("unknown token key")
+	// ErrKeyType is returned when the JWKS key of a token does not match its
+	// signing method
+	ErrKeyType = errors.New("token key does not match the signing method")
+)
+
+const (
+	// jwksCacheTTL is how long fetched keys are used without a refresh
+	jwksCacheTTL = time.Hour
+	// jwksRefreshInterval is the minimum time between two fetches, unknown
+	// kids can not make the verifier flood the identity provider.
+	jwksRefreshInterval = 30 * time.Second
+	// jwksTimeout is the timeout of a JWKS fetch
+	jwksTimeout = 5 * time.Second
+)
+
+// jwk is a JSON web key, only RSA and P-256 signing keys are used
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// JWKS caches the keys of a JWKS document by kid. Keys are refreshed when they
+// are older than jwksCacheTTL or an unknown kid is seen, at most once every
+// jwksRefreshInterval. Tokens are rejected when the document can not be fetched.
+type JWKS struct {
+	url    string
+	client *http.Client
+	now    func() time.Time
+
+	cacheTTL        time.Duration
+	refreshInterval time.Duration
+
+	mu          sync.Mutex
+	keys        map[string]interface{}
+	fetchedAt   time.Time
+	refreshedAt time.Time
+	fetching    *jwksFetch
+}
+
+// NewJWKS returns a JWKS for url, keys are fetched on first use
+func NewJWKS(url string, client *http.Client) *JWKS {
+	if client == nil {
+		client = &http.Client{Timeout: jwksTimeout}
+	}
+
+	return &JWKS{
+		url:             url,
+		client:          client,
+		now:             time.Now,
+		cacheTTL:        jwksCacheTTL,
+		refreshInterval: jwksRefreshInterval,
+	}
+}
+
+// jwksFetch is a fetch of the JWKS document shared by the callers waiting
+// for it, it is canceled when all of them are gone.
+type jwksFetch struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	keys    map[string]interface{}
+	err     error
+}
+
+// Key returns the public key for kid. The document is fetched outside the
+// lock by one caller at a time, cached keys are served while a refresh runs
+// and callers waiting for the fetch return when ctx is done.
+func (j *JWKS) Key(ctx context.Context, kid string) (interface{}, error) {
+	j.mu.Lock()
+	now := j.now()
+	key, ok := j.keys[kid]
+	stale := now.Sub(j.fetchedAt) >= j.cacheTTL
+	if ok && (!stale || j.fetching != nil) {
+		j.mu.Unlock()
+		return key, nil
+	}
+
+	f := j.fetching
+	if f == nil {
+		// stale keys are not used when the refresh is rate limited or fails
+		if now.Sub(j.refreshedAt) < j.refreshInterval {
+			j.mu.Unlock()
+			return nil, ErrUnknownKey
+		}
+		j.refreshedAt = now
+		f = j.startFetch(now)
+	}
+	f.waiters++
+	j.mu.Unlock()
+
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		j.mu.Lock()
+		if f.waiters--; f.waiters == 0 {
+			f.cancel()
+		}
+		j.mu.Unlock()
+		return nil, ctx.Err()
+	}
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	if key, ok := f.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// startFetch fetches the document in the background, j.mu must be held
+func (j *JWKS) startFetch(now time.Time) *jwksFetch {
+	ctx, cancel := context.WithTimeout(context.Background(), jwksTimeout)
+	f := &jwksFetch{done: make(chan struct{}), cancel: cancel}
+	j.fetching = f
+
+	go func() {
+		keys, err := j.fetch(ctx)
+		cancel()
+
+		j.mu.Lock()
+		if err == nil {
+			j.keys = keys
+			j.fetchedAt = now
+		}
+		j.fetching = nil
+		j.mu.Unlock()
+
+		f.keys, f.err = keys, err
+		close(f.done)
+	}()
+
+	return f
+}
+
+// fetch downloads the JWKS document and parses its signing keys
+func (j *JWKS) fetch(ctx context.Context) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := j.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching jwks: unexpected status %d", res.StatusCode)
+	}
+
+	var document struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&document); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{})
+	for _, k := range document.Keys {
+		if k.Kid == "" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
+
+// publicKey decodes the RSA or ECDSA public key of k
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() {
+			return nil, fmt.Errorf("invalid rsa exponent of key %s", k.Kid)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s of key %s", k.Crv, k.Kid)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, fmt.Errorf("invalid point of key %s", k.Kid)
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %s of key %s", k.Kty, k.Kid)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,273 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+// jwksServer serves the keys of a JWKS document and counts its fetches
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    []jwk
+	status  int
+	fetches int
+	// release blocks fetches until it is closed when it is set
+	release chan struct{}
+}
+
+func newJWKSServer(t *testing.T) *jwksServer {
+	s := &jwksServer{status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.fetches++
+		release := s.release
+		s.mu.Unlock()
+
+		if release != nil {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		w.WriteHeader(s.status)
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.keys})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) set(status int, keys ...jwk) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status, s.keys = status, keys
+}
+
+func (s *jwksServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) jwk {
+	return jwk{Kid: kid, Kty: "RSA", Use: "sig", N: encodeBigInt(key.N), E: encodeBigInt(big.NewInt(int64(key.E)))}
+}
+
+func ecdsaJWK(kid string, key *ecdsa.PublicKey) jwk {
+	return jwk{Kid: kid, Kty: "EC", Crv: "P-256", X: encodeBigInt(key.X), Y: encodeBigInt(key.Y)}
+}
+
+func signWithKid(t *testing.T, method jwt.SigningMethod, kid string, key interface{}) string {
+	token := jwt.NewWithClaims(method, jwt.MapClaims{"authenticated": true})
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	assert.Nil(t, err)
+	return signed
+}
+
+func Test_JWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	rotatedKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	server := newJWKSServer(t)
+	server.set(http.StatusOK, rsaJWK("rsa", &rsaKey.PublicKey), ecdsaJWK("ec", &ecdsaKey.PublicKey))
+
+	v, err := NewVerifier(VerifierConfig{JWKSURL: server.URL})
+	assert.Nil(t, err)
+
+	now := time.Now()
+	v.jwks.now = func() time.Time { return now }
+
+	// keys are fetched once and cached by kid
+	_, err = v.Verify(context.Background(), signWithKid(t, jwt.SigningMethodRS256, "rsa", rsaKey))
+	assert.Nil(t, err)
+	_, err = v.Verify(context.Background(), signWithKid(t, jwt.SigningMethodES256, "ec", ecdsaKey))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, server.count())
+
+	// the key of a kid must match the signing method
+	_, err = v.Verify(context.Background(), signWithKid(t, jwt.SigningMethodRS256, "ec", rsaKey))
+	assert.Equal(t, ErrKeyType, err)
+
+	// a token signed with another key is rejected
+	_, err = v.Verify(context.Background(), signWithKid(t, jwt.SigningMethodRS256, "rsa", rotatedKey))
+	assert.NotNil(t, err)
+
+	// unknown kids are refreshed at most once every refresh interval
+	server.set(http.StatusOK, rsaJWK("rotated", &rotatedKey.PublicKey))
+	rotated := signWithKid(t, jwt.SigningMethodRS256, "rotated", rotatedKey)
+	_, err = v.Verify(context.Background(), rotated)
+	assert.Equal(t, ErrUnknownKey, err)
+	assert.Equal(t, 1, server.count())
+
+	now = now.Add(jwksRefreshInterval)
+	_, err = v.Verify(context.Background(), rotated)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, server.count())
+
+	// keys removed from the document are not accepted after the refresh
+	_, err = v.Verify(context.Background(), signWithKid(t, jwt.SigningMethodRS256, "rsa", rsaKey))
+	assert.Equal(t, ErrUnknownKey, err)
+
+	// cached keys are used when a refresh fails
+	server.set(http.StatusInternalServerError)
+	now = now.Add(jwksRefreshInterval)
+	_, err = v.Verify(context.Background(), signWithKid(t, jwt.SigningMethodRS256, "unknown", rsaKey))
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, server.count())
+	_, err = v.Verify(context.Background(), rotated)
+	assert.Nil(t, err)
+
+	// stale keys are rejected when they can not be refreshed
+	now = now.Add(jwksCacheTTL)
+	_, err = v.Verify(context.Background(), rotated)
+	assert.NotNil(t, err)
+	assert.Equal(t, 4, server.count())
+
+	_, err = v.Verify(context.Background(), rotated)
+	assert.Equal(t, ErrUnknownKey, err)
+	assert.Equal(t, 4, server.count())
+}
+
+func Test_JWKS_SharedFetch(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	server := newJWKSServer(t)
+	server.set(http.StatusOK, rsaJWK("rsa", &key.PublicKey))
+	release := make(chan struct{})
+	server.release = release
+
+	j := NewJWKS(server.URL, nil)
+	now := time.Now()
+	j.now = func() time.Time { return now }
+
+	// concurrent callers wait for one fetch
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := j.Key(context.Background(), "rsa")
+			assert.Nil(t, err)
+		}()
+	}
+	assert.Eventually(t, func() bool { return server.count() == 1 }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, 1, server.count())
+
+	// cached keys are served while a refresh runs
+	release = make(chan struct{})
+	server.mu.Lock()
+	server.release = release
+	server.mu.Unlock()
+	now = now.Add(jwksCacheTTL)
+
+	refreshed := make(chan error)
+	go func() {
+		_, err := j.Key(context.Background(), "rsa")
+		refreshed <- err
+	}()
+	assert.Eventually(t, func() bool { return server.count() == 2 }, time.Second, time.Millisecond)
+
+	cached, err := j.Key(context.Background(), "rsa")
+	assert.Nil(t, err)
+	assert.Equal(t, &key.PublicKey, cached)
+
+	// callers waiting for the fetch return when their context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = j.Key(ctx, "unknown")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	close(release)
+	assert.Nil(t, <-refreshed)
+	assert.Equal(t, 2, server.count())
+}
+
+func Test_JWKS_CancelFetch(t *testing.T) {
+	started, canceled := make(chan struct{}), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+		close(canceled)
+	}))
+	defer server.Close()
+
+	j := NewJWKS(server.URL, nil)
+
+	// the fetch is canceled when no caller waits for it
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	_, err := j.Key(ctx, "rsa")
+	assert.Equal(t, context.Canceled, err)
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("the fetch was not canceled")
+	}
+}
+
+func Test_JWKS_Unavailable(t *testing.T) {
+	server := newJWKSServer(t)
+	server.Close()
+
+	v, err := NewVerifier(VerifierConfig{JWKSURL: server.URL})
+	assert.Nil(t, err)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	_, err = v.Verify(context.Background(), signWithKid(t, jwt.SigningMethodRS256, "rsa", key))
+	assert.NotNil(t, err)
+}
+
+func Test_jwk_publicKey(t *testing.T) {
+	tests := []struct {
+		name string
+		key  jwk
+	}{
+		{"unsupported_type", jwk{Kid: "oct", Kty: "oct"}},
+		{"unsupported_curve", jwk{Kid: "ec", Kty: "EC", Crv: "P-384"}},
+		{"invalid_point", jwk{Kid: "ec", Kty: "EC", Crv: "P-256", X: "AQ", Y: "AQ"}},
+		{"invalid_encoding", jwk{Kid: "rsa", Kty: "RSA", N: "!", E: "AQAB"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := test.key.publicKey()
+			assert.NotNil(t, err)
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/s3f4/locationmatcher/pkg/apihelper"
+)
+
+// Scopes granted to tokens
+const (
+	// ScopeDriver lets driver apps upsert the location of their own driver_id
+	ScopeDriver = "driver"
+	// ScopeDispatch lets dispatch backends find nearest drivers
+	ScopeDispatch = "dispatch"
+	// ScopeAdmin lets admins run migrations and upsert any driver location
+	ScopeAdmin = "admin"
+)
+
+// Scopes returns the space separated scope claim and the scp claim of claims
+func Scopes(claims jwt.MapClaims) []string {
+	var scopes []string
+	if scope, ok := claims["scope"].(string); ok {
+		scopes = append(scopes, strings.Fields(scope)...)
+	}
+
+	switch scp := claims["scp"].(type) {
+	case string:
+		scopes = append(scopes, strings.Fields(scp)...)
+	case []string:
+		scopes = append(scopes, scp...)
+	case []interface{}:
+		for _, s := range scp {
+			if s, ok := s.(string); ok {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+
+	return scopes
+}
+
+// HasScope reports whether claims grant one of scopes
+func HasScope(claims jwt.MapClaims, scopes ...string) bool {
+	for _, granted := range Scopes(claims) {
+		for _, scope := range scopes {
+			if granted == scope {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DriverID returns the driver_id claim of a driver token
+func DriverID(claims jwt.MapClaims) string {
+	driverID, _ := claims["driver_id"].(string)
+	return driverID
+}
+
+// RequireScope allows requests whose claims in the context grant one of
+// scopes, others are forbidden.
+func RequireScope(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				apihelper.Send401(w)
+				return
+			}
+
+			if !HasScope(claims, scopes...) {
+				apihelper.Send403(w)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Scopes(t *testing.T) {
+	assert.Equal(t, []string{"driver", "dispatch"}, Scopes(jwt.MapClaims{"scope": "driver dispatch"}))
+	assert.Equal(t, []string{"admin"}, Scopes(jwt.MapClaims{"scp": []interface{}{"admin", 1}}))
+	assert.Equal(t, []string{"driver", "admin"}, Scopes(jwt.MapClaims{"scope": "driver", "scp": "admin"}))
+	assert.Len(t, Scopes(jwt.MapClaims{}), 0)
+
+	assert.True(t, HasScope(jwt.MapClaims{"scope": "driver dispatch"}, ScopeAdmin, ScopeDispatch))
+	assert.False(t, HasScope(jwt.MapClaims{"scope": "driver"}, ScopeAdmin))
+}
+
+func Test_RequireScope(t *testing.T) {
+	handler := RequireScope(ScopeDispatch, ScopeAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name         string
+		claims       jwt.MapClaims
+		expectedBody string
+	}{
+		{"dispatch", jwt.MapClaims{"scope": "dispatch"}, ``},
+		{"admin", jwt.MapClaims{"scp": []interface{}{"admin"}}, ``},
+		{"driver", jwt.MapClaims{"scope": "driver"}, `{"code":403,"msg":"Forbidden"}`},
+		{"no_claims", nil, `{"code":401,"msg":"Unauthorized"}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/find_nearest", nil)
+			if test.claims != nil {
+				req = req.WithContext(WithClaims(req.Context(), test.claims))
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, test.expectedBody, w.Body.String())
+		})
+	}
+}
@@ -0,0 +1,257 @@
+// Package auth verifies JWTs and authorizes requests by their claims.
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/s3f4/locationmatcher/pkg/log"
+)
+
+var (
+	// ErrNoKeys is returned when the verifier has no keys, tokens are never
+	// accepted without a key.
+	ErrNoKeys = errors.New("no token verification keys are configured")
+	// ErrInvalidSignature is returned when no configured key verifies the token
+	ErrInvalidSignature = errors.New("invalid token signature")
+	// ErrInvalidIssuer is returned when the iss claim is not the configured issuer
+	ErrInvalidIssuer = errors.New("invalid token issuer")
+	// ErrInvalidAudience is returned when the aud claim does not contain the
+	// configured audience
+	ErrInvalidAudience = errors.New("invalid token audience")
+)
+
+// validMethods are the signing methods accepted by the verifier
+var validMethods = []string{
+	jwt.SigningMethodHS256.Alg(),
+	jwt.SigningMethodRS256.Alg(),
+	jwt.SigningMethodES256.Alg(),
+}
+
+// VerifierConfig holds the keys and the expected claims of a Verifier
+type VerifierConfig struct {
+	// HMACSecrets verify HS256 tokens, more than one secret allows rotation
+	HMACSecrets []string
+	// PublicKeys are PEM encoded RSA or ECDSA public keys, they verify RS256
+	// and ES256 tokens.
+	PublicKeys [][]byte
+	// JWKSURL is the JWKS document of the identity provider, it verifies
+	// RS256 and ES256 tokens with a kid header.
+	JWKSURL string
+	// Issuer is the required iss claim, it is not checked when empty
+	Issuer string
+	// Audience is the required aud claim, it is not checked when empty
+	Audience string
+}
+
+// Verifier verifies token signatures and exp, nbf, iss and aud claims
+type Verifier struct {
+	hmacSecrets [][]byte
+	rsaKeys     []*rsa.PublicKey
+	ecdsaKeys   []*ecdsa.PublicKey
+	jwks        *JWKS
+	issuer      string
+	audience    string
+	now         func() time.Time
+}
+
+// NewVerifier parses the public keys of config and returns a Verifier
+func NewVerifier(config VerifierConfig) (*Verifier, error) {
+	v := &Verifier{
+		issuer:   config.Issuer,
+		audience: config.Audience,
+		now:      time.Now,
+	}
+
+	if config.JWKSURL != "" {
+		v.jwks = NewJWKS(config.JWKSURL, nil)
+	}
+
+	for _, secret := range config.HMACSecrets {
+		if secret != "" {
+			v.hmacSecrets = append(v.hmacSecrets, []byte(secret))
+		}
+	}
+
+	for _, key := range config.PublicKeys {
+		if rsaKey, err := jwt.ParseRSAPublicKeyFromPEM(key); err == nil {
+			v.rsaKeys = append(v.rsaKeys, rsaKey)
+			continue
+		}
+
+		ecdsaKey, err := jwt.ParseECPublicKeyFromPEM(key)
+		if err != nil {
+			return nil, err
+		}
+		v.ecdsaKeys = append(v.ecdsaKeys, ecdsaKey)
+	}
+
+	return v, nil
+}
+
+// VerifierFromEnv builds a verifier from JWT_HMAC_SECRETS and
+// JWT_PUBLIC_KEYS, comma separated secrets and PEM file paths, JWT_JWKS_URL,
+// JWT_ISSUER and JWT_AUDIENCE. Invalid keys are logged and every token is
+// rejected.
+func VerifierFromEnv() *Verifier {
+	config := VerifierConfig{
+		HMACSecrets: splitList(os.Getenv("JWT_HMAC_SECRETS")),
+		JWKSURL:     os.Getenv("JWT_JWKS_URL"),
+		Issuer:      os.Getenv("JWT_ISSUER"),
+		Audience:    os.Getenv("JWT_AUDIENCE"),
+	}
+
+	for _, path := range splitList(os.Getenv("JWT_PUBLIC_KEYS")) {
+		key, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Errorf("reading jwt public key %s: %s", path, err)
+			return &Verifier{}
+		}
+		config.PublicKeys = append(config.PublicKeys, key)
+	}
+
+	v, err := NewVerifier(config)
+	if err != nil {
+		log.Errorf("parsing jwt public keys: %s", err)
+		return &Verifier{}
+	}
+
+	return v
+}
+
+// Verify verifies the signature and the claims of tokenStr and returns its
+// claims, ctx cancels waiting for the JWKS keys.
+func (v *Verifier) Verify(ctx context.Context, tokenStr string) (jwt.MapClaims, error) {
+	if len(v.hmacSecrets)+len(v.rsaKeys)+len(v.ecdsaKeys) == 0 && v.jwks == nil {
+		return nil, ErrNoKeys
+	}
+
+	parser := &jwt.Parser{ValidMethods: validMethods, SkipClaimsValidation: true}
+	unverified, _, err := parser.ParseUnverified(tokenStr, jwt.MapClaims{})
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := v.keys(ctx, unverified)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		claims := jwt.MapClaims{}
+		_, err := parser.ParseWithClaims(tokenStr, claims, func(*jwt.Token) (interface{}, error) {
+			return key, nil
+		})
+		if err == nil {
+			if err := v.validate(claims); err != nil {
+				return nil, err
+			}
+			return claims, nil
+		}
+
+		// try the next key only when the signature does not match
+		if ve, ok := err.(*jwt.ValidationError); !ok || ve.Errors&jwt.ValidationErrorSignatureInvalid == 0 {
+			return nil, err
+		}
+	}
+
+	return nil, ErrInvalidSignature
+}
+
+// keys returns the keys that may verify token, the JWKS key of its kid or the
+// configured keys for its signing method.
+func (v *Verifier) keys(ctx context.Context, token *jwt.Token) ([]interface{}, error) {
+	alg := token.Method.Alg()
+	if kid, ok := token.Header["kid"].(string); ok && v.jwks != nil && alg != jwt.SigningMethodHS256.Alg() {
+		key, err := v.jwks.Key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+
+		switch key.(type) {
+		case *rsa.PublicKey:
+			if alg != jwt.SigningMethodRS256.Alg() {
+				return nil, ErrKeyType
+			}
+		case *ecdsa.PublicKey:
+			if alg != jwt.SigningMethodES256.Alg() {
+				return nil, ErrKeyType
+			}
+		}
+		return []interface{}{key}, nil
+	}
+
+	var keys []interface{}
+	switch alg {
+	case jwt.SigningMethodHS256.Alg():
+		for _, secret := range v.hmacSecrets {
+			keys = append(keys, secret)
+		}
+	case jwt.SigningMethodRS256.Alg():
+		for _, key := range v.rsaKeys {
+			keys = append(keys, key)
+		}
+	case jwt.SigningMethodES256.Alg():
+		for _, key := range v.ecdsaKeys {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// validate checks exp, nbf, iss and aud claims
+func (v *Verifier) validate(claims jwt.MapClaims) error {
+	now := v.now().Unix()
+	if !claims.VerifyExpiresAt(now, false) {
+		return jwt.NewValidationError("token is expired", jwt.ValidationErrorExpired)
+	}
+
+	if !claims.VerifyNotBefore(now, false) {
+		return jwt.NewValidationError("token is not valid yet", jwt.ValidationErrorNotValidYet)
+	}
+
+	if v.issuer != "" && !claims.VerifyIssuer(v.issuer, true) {
+		return ErrInvalidIssuer
+	}
+
+	if v.audience != "" && !claims.VerifyAudience(v.audience, true) {
+		return ErrInvalidAudience
+	}
+
+	return nil
+}
+
+type claimsKey struct{}
+
+// WithClaims returns a copy of ctx that carries verified claims, the logger
+// of ctx logs the sub claim as the user.
+func WithClaims(ctx context.Context, claims jwt.MapClaims) context.Context {
+	if sub, ok := claims["sub"].(string); ok && sub != "" {
+		ctx = log.WithContext(ctx, log.UserKey, sub)
+	}
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFromContext returns the verified claims of the request
+func ClaimsFromContext(ctx context.Context) (jwt.MapClaims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(jwt.MapClaims)
+	return claims, ok
+}
+
+// splitList splits a comma separated list and drops empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	assert.Nil(t, err)
+	return token
+}
+
+func publicKeyPEM(t *testing.T, key interface{}) []byte {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	assert.Nil(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func Test_Verifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	otherRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	v, err := NewVerifier(VerifierConfig{
+		HMACSecrets: []string{"old-secret", "new-secret"},
+		PublicKeys:  [][]byte{publicKeyPEM(t, &rsaKey.PublicKey), publicKeyPEM(t, &ecdsaKey.PublicKey)},
+		Issuer:      "auth",
+		Audience:    "matching",
+	})
+	assert.Nil(t, err)
+
+	now := time.Now()
+	claims := func(modify func(jwt.MapClaims)) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"authenticated": true,
+			"iss":           "auth",
+			"aud":           []string{"driverlocation", "matching"},
+			"exp":           now.Add(time.Minute).Unix(),
+			"nbf":           now.Add(-time.Minute).Unix(),
+		}
+		if modify != nil {
+			modify(c)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   bool
+	}{
+		{"hs256", sign(t, jwt.SigningMethodHS256, []byte("new-secret"), claims(nil)), false},
+		{"hs256_rotated", sign(t, jwt.SigningMethodHS256, []byte("old-secret"), claims(nil)), false},
+		{"rs256", sign(t, jwt.SigningMethodRS256, rsaKey, claims(nil)), false},
+		{"es256", sign(t, jwt.SigningMethodES256, ecdsaKey, claims(nil)), false},
+		{"unknown_secret", sign(t, jwt.SigningMethodHS256, []byte("secret"), claims(nil)), true},
+		{"unknown_key", sign(t, jwt.SigningMethodRS256, otherRSAKey, claims(nil)), true},
+		{"unsupported_method", sign(t, jwt.SigningMethodHS512, []byte("new-secret"), claims(nil)), true},
+		{"none", sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, claims(nil)), true},
+		{"expired", sign(t, jwt.SigningMethodHS256, []byte("new-secret"), claims(func(c jwt.MapClaims) {
+			c["exp"] = now.Add(-time.Minute).Unix()
+		})), true},
+		{"not_before", sign(t, jwt.SigningMethodHS256, []byte("new-secret"), claims(func(c jwt.MapClaims) {
+			c["nbf"] = now.Add(time.Minute).Unix()
+		})), true},
+		{"issuer", sign(t, jwt.SigningMethodHS256, []byte("new-secret"), claims(func(c jwt.MapClaims) {
+			c["iss"] = "other"
+		})), true},
+		{"audience", sign(t, jwt.SigningMethodHS256, []byte("new-secret"), claims(func(c jwt.MapClaims) {
+			c["aud"] = "driverlocation"
+		})), true},
+		{"malformed", "token", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verified, err := v.Verify(context.Background(), test.token)
+			if test.err {
+				assert.NotNil(t, err)
+				assert.Nil(t, verified)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, true, verified["authenticated"])
+		})
+	}
+}
+
+func Test_Verifier_NoKeys(t *testing.T) {
+	v, err := NewVerifier(VerifierConfig{})
+	assert.Nil(t, err)
+
+	_, err = v.Verify(context.Background(), sign(t, jwt.SigningMethodHS256, []byte("secret"), jwt.MapClaims{"authenticated": true}))
+	assert.Equal(t, ErrNoKeys, err)
+}
+
+func Test_NewVerifier_InvalidKey(t *testing.T) {
+	_, err := NewVerifier(VerifierConfig{PublicKeys: [][]byte{[]byte("key")}})
+	assert.NotNil(t, err)
+}
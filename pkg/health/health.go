@@ -0,0 +1,109 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/s3f4/locationmatcher/pkg/apihelper"
+	"github.com/s3f4/locationmatcher/pkg/shutdown"
+)
+
+// Statuses of components and reports
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Timeout bounds the checks of a readiness request
+var Timeout = 2 * time.Second
+
+// Component is the health of a dependency or a part of a service
+type Component struct {
+	Status  string            `json:"status"`
+	Error   string            `json:"error,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Report is the response of the health endpoints
+type Report struct {
+	Status     string               `json:"status"`
+	Components map[string]Component `json:"components,omitempty"`
+}
+
+// Check returns the health of a component
+type Check func(ctx context.Context) Component
+
+// Checks are the checks of components by name
+type Checks map[string]Check
+
+// FromError returns an up component for a nil error, a down component with
+// the error otherwise.
+func FromError(err error) Component {
+	if err != nil {
+		return Component{Status: StatusDown, Error: err.Error()}
+	}
+	return Component{Status: StatusUp}
+}
+
+// Serving checks the server is not draining
+func Serving(readiness *shutdown.Readiness) Check {
+	return func(context.Context) Component {
+		if !readiness.Ready() {
+			return Component{Status: StatusDown, Error: "server is draining"}
+		}
+		return Component{Status: StatusUp}
+	}
+}
+
+// Live responds that the process is up. Dependencies are not checked so that
+// an unavailable dependency does not restart the service.
+func Live(w http.ResponseWriter, r *http.Request) {
+	apihelper.SendResponse(w, http.StatusOK, Report{Status: StatusUp})
+}
+
+// Ready runs checks concurrently within Timeout and responds 200 when every
+// component is up, 503 otherwise.
+func Ready(checks Checks) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), Timeout)
+		defer cancel()
+
+		report := Run(ctx, checks)
+		status := http.StatusOK
+		if report.Status != StatusUp {
+			status = http.StatusServiceUnavailable
+		}
+
+		apihelper.SendResponse(w, status, report)
+	}
+}
+
+// Run runs checks concurrently, the report is up when every component is up
+func Run(ctx context.Context, checks Checks) Report {
+	report := Report{
+		Status:     StatusUp,
+		Components: make(map[string]Component, len(checks)),
+	}
+
+	var m sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			component := check(ctx)
+
+			m.Lock()
+			defer m.Unlock()
+			report.Components[name] = component
+			if component.Status != StatusUp {
+				report.Status = StatusDown
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	return report
+}
@@ -0,0 +1,66 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/s3f4/locationmatcher/pkg/shutdown"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Live(t *testing.T) {
+	w := httptest.NewRecorder()
+	Live(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"status":"up"}`, w.Body.String())
+}
+
+func Test_Ready(t *testing.T) {
+	var readiness shutdown.Readiness
+	checks := Checks{
+		"server": Serving(&readiness),
+		"repository": func(context.Context) Component {
+			component := FromError(nil)
+			component.Details = map[string]string{"backend": "memory"}
+			return component
+		},
+	}
+
+	w := httptest.NewRecorder()
+	Ready(checks)(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"status":"up","components":{"repository":{"status":"up","details":{"backend":"memory"}},"server":{"status":"up"}}}`, w.Body.String())
+
+	readiness.Drain()
+	checks["index"] = func(context.Context) Component {
+		return FromError(errors.New("index does not exist"))
+	}
+
+	w = httptest.NewRecorder()
+	Ready(checks)(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, `{"status":"down","components":{"index":{"status":"down","error":"index does not exist"},"repository":{"status":"up","details":{"backend":"memory"}},"server":{"status":"down","error":"server is draining"}}}`, w.Body.String())
+}
+
+func Test_Ready_Timeout(t *testing.T) {
+	old := Timeout
+	Timeout = 10 * time.Millisecond
+	defer func() { Timeout = old }()
+
+	checks := Checks{
+		"slow": func(ctx context.Context) Component {
+			<-ctx.Done()
+			return FromError(ctx.Err())
+		},
+	}
+
+	w := httptest.NewRecorder()
+	Ready(checks)(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, `{"status":"down","components":{"slow":{"status":"down","error":"context deadline exceeded"}}}`, w.Body.String())
+}
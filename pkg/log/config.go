@@ -0,0 +1,320 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Encodings of log entries
+const (
+	EncodingJSON    = "json"
+	EncodingConsole = "console"
+)
+
+// samplingTick is the interval of sampling, the first SamplingInitial
+// entries with the same level and message are logged in every tick and then
+// every SamplingThereafter one.
+const samplingTick = time.Second
+
+// AccessLogger is the name of the access log, its entries are never sampled
+// so that every logged request is kept.
+const AccessLogger = "access"
+
+// Config configures the logger
+type Config struct {
+	// Level is the minimum level of logged entries, it can be changed at
+	// runtime by LevelHandler.
+	Level zapcore.Level
+	// Encoding is EncodingJSON or EncodingConsole
+	Encoding string
+	// OutputPaths are stdout, stderr or file paths
+	OutputPaths []string
+	// SamplingInitial 0 disables sampling
+	SamplingInitial    int
+	SamplingThereafter int
+	// Caller adds the file and line of the caller to entries
+	Caller bool
+	// MaxSize is the size in megabytes after which files are rotated, 0
+	// disables rotation.
+	MaxSize int
+	// MaxBackups is the number of rotated files that are kept, 0 keeps all
+	MaxBackups int
+}
+
+// DefaultConfig logs debug entries to stdout as json
+func DefaultConfig() Config {
+	return Config{
+		Level:              zapcore.DebugLevel,
+		Encoding:           EncodingJSON,
+		OutputPaths:        []string{"stdout"},
+		SamplingInitial:    100,
+		SamplingThereafter: 100,
+		Caller:             true,
+	}
+}
+
+var (
+	// level is shared by every logger that is built, so LevelHandler keeps
+	// working after the logger is reconfigured.
+	level = zap.NewAtomicLevelAt(zapcore.DebugLevel)
+
+	// current holds the core of the last Configure, loggers write to it
+	// through a switchCore so that loggers of contexts use the new sinks.
+	current atomic.Value
+
+	// files are the file sinks of the current logger
+	filesM sync.Mutex
+	files  []io.Closer
+)
+
+// ConfigFromEnv reads LOG_LEVEL, LOG_ENCODING, LOG_OUTPUT_PATHS (comma
+// separated), LOG_SAMPLING_INITIAL, LOG_SAMPLING_THEREAFTER, LOG_CALLER,
+// LOG_FILE_MAX_SIZE and LOG_FILE_MAX_BACKUPS. Invalid values are replaced by
+// the defaults and returned as warnings, the logger does not exist yet.
+func ConfigFromEnv() (Config, []string) {
+	config := DefaultConfig()
+	var warnings []string
+	warn := func(key, value string, fallback interface{}) {
+		warnings = append(warnings, fmt.Sprintf("invalid %s %q, %v is used", key, value, fallback))
+	}
+
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		if err := config.Level.UnmarshalText([]byte(value)); err != nil {
+			config.Level = zapcore.DebugLevel
+			warn("LOG_LEVEL", value, config.Level)
+		}
+	}
+
+	switch value := os.Getenv("LOG_ENCODING"); value {
+	case "":
+	case EncodingJSON, EncodingConsole:
+		config.Encoding = value
+	default:
+		warn("LOG_ENCODING", value, config.Encoding)
+	}
+
+	if value := os.Getenv("LOG_OUTPUT_PATHS"); value != "" {
+		var paths []string
+		for _, path := range strings.Split(value, ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				paths = append(paths, path)
+			}
+		}
+		if len(paths) > 0 {
+			config.OutputPaths = paths
+		}
+	}
+
+	ints := []struct {
+		key   string
+		value *int
+	}{
+		{"LOG_SAMPLING_INITIAL", &config.SamplingInitial},
+		{"LOG_SAMPLING_THEREAFTER", &config.SamplingThereafter},
+		{"LOG_FILE_MAX_SIZE", &config.MaxSize},
+		{"LOG_FILE_MAX_BACKUPS", &config.MaxBackups},
+	}
+	for _, i := range ints {
+		if value := os.Getenv(i.key); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				warn(i.key, value, *i.value)
+				continue
+			}
+			*i.value = n
+		}
+	}
+
+	if value := os.Getenv("LOG_CALLER"); value != "" {
+		caller, err := strconv.ParseBool(value)
+		if err != nil {
+			warn("LOG_CALLER", value, config.Caller)
+		} else {
+			config.Caller = caller
+		}
+	}
+
+	return config, warnings
+}
+
+// Configure replaces the logger with a logger built from config
+func Configure(config Config) error {
+	sink, closers, err := openSinks(config)
+	if err != nil {
+		return err
+	}
+
+	errorSink, _, err := zap.Open("stderr")
+	if err != nil {
+		return err
+	}
+
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.TimeKey = "time"
+	encoderConfig.EncodeTime = zapcore.TimeEncoderOfLayout(time.RFC3339)
+
+	var encoder zapcore.Encoder
+	switch config.Encoding {
+	case EncodingJSON, "":
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	case EncodingConsole:
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	default:
+		return fmt.Errorf("no such encoding %s", config.Encoding)
+	}
+
+	level.SetLevel(config.Level)
+	core := zapcore.NewCore(encoder, sink, level)
+	if config.SamplingInitial > 0 {
+		core = &sampledCore{
+			Core:    core,
+			sampler: zapcore.NewSamplerWithOptions(core, samplingTick, config.SamplingInitial, config.SamplingThereafter),
+		}
+	}
+
+	options := []zap.Option{zap.ErrorOutput(errorSink), zap.AddStacktrace(zapcore.ErrorLevel), zap.AddCallerSkip(1)}
+	if config.Caller {
+		options = append(options, zap.AddCaller())
+	}
+	current.Store(coreValue{core})
+	setLogger(zap.New(&switchCore{}, options...))
+
+	// loggers built before write to the new sinks, only entries that are
+	// being written can reach the closed files
+	filesM.Lock()
+	previous := files
+	files = closers
+	filesM.Unlock()
+	for _, file := range previous {
+		file.Close()
+	}
+
+	return nil
+}
+
+// coreValue wraps the cores stored in current, an atomic.Value needs one
+// concrete type
+type coreValue struct {
+	zapcore.Core
+}
+
+// switchCore writes entries to the core of the last Configure with the
+// fields of With, the core with the fields is built once per configured core.
+type switchCore struct {
+	fields []zapcore.Field
+	bound  atomic.Value
+}
+
+// boundCore is a configured core and the same core with the fields of a
+// switchCore
+type boundCore struct {
+	base, core zapcore.Core
+}
+
+func (c *switchCore) core() zapcore.Core {
+	base := current.Load().(coreValue).Core
+	if bound, ok := c.bound.Load().(boundCore); ok && bound.base == base {
+		return bound.core
+	}
+
+	core := base
+	if len(c.fields) > 0 {
+		core = base.With(c.fields)
+	}
+	c.bound.Store(boundCore{base: base, core: core})
+	return core
+}
+
+func (c *switchCore) Enabled(l zapcore.Level) bool {
+	return current.Load().(coreValue).Enabled(l)
+}
+
+func (c *switchCore) With(fields []zapcore.Field) zapcore.Core {
+	all := make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	all = append(append(all, c.fields...), fields...)
+	return &switchCore{fields: all}
+}
+
+// Check adds the configured core to checked, entries are not written by c
+func (c *switchCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return c.core().Check(entry, checked)
+}
+
+func (c *switchCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	return c.core().Write(entry, fields)
+}
+
+func (c *switchCore) Sync() error {
+	return current.Load().(coreValue).Sync()
+}
+
+// sampledCore samples entries with sampler except the entries of the access
+// logger, which are written by Core.
+type sampledCore struct {
+	zapcore.Core
+	sampler zapcore.Core
+}
+
+func (c *sampledCore) With(fields []zapcore.Field) zapcore.Core {
+	return &sampledCore{Core: c.Core.With(fields), sampler: c.sampler.With(fields)}
+}
+
+func (c *sampledCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if entry.LoggerName == AccessLogger || strings.HasSuffix(entry.LoggerName, "."+AccessLogger) {
+		return c.Core.Check(entry, checked)
+	}
+	return c.sampler.Check(entry, checked)
+}
+
+// openSinks opens the output paths of config, files are rotated when
+// config.MaxSize is set.
+func openSinks(config Config) (zapcore.WriteSyncer, []io.Closer, error) {
+	var syncers []zapcore.WriteSyncer
+	var closers []io.Closer
+	for _, path := range config.OutputPaths {
+		switch {
+		case path == "stdout" || path == "stderr":
+			syncer, _, err := zap.Open(path)
+			if err != nil {
+				return nil, nil, err
+			}
+			syncers = append(syncers, syncer)
+
+		case config.MaxSize > 0:
+			file := &lumberjack.Logger{
+				Filename:   path,
+				MaxSize:    config.MaxSize,
+				MaxBackups: config.MaxBackups,
+			}
+			syncers = append(syncers, zapcore.AddSync(file))
+			closers = append(closers, file)
+
+		default:
+			file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+			if err != nil {
+				return nil, nil, err
+			}
+			syncers = append(syncers, file)
+			closers = append(closers, file)
+		}
+	}
+
+	return zapcore.NewMultiWriteSyncer(syncers...), closers, nil
+}
+
+// LevelHandler serves the level of the logger, GET returns it as
+// {"level":"info"} and PUT changes it with the same body.
+func LevelHandler() http.Handler {
+	return level
+}
@@ -0,0 +1,166 @@
+package log
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+)
+
+func Test_ConfigFromEnv(t *testing.T) {
+	config, warnings := ConfigFromEnv()
+	assert.Equal(t, DefaultConfig(), config)
+	assert.Empty(t, warnings)
+
+	t.Setenv("LOG_LEVEL", "warn")
+	t.Setenv("LOG_ENCODING", EncodingConsole)
+	t.Setenv("LOG_OUTPUT_PATHS", "stdout, /var/log/driverlocation.log")
+	t.Setenv("LOG_SAMPLING_INITIAL", "0")
+	t.Setenv("LOG_CALLER", "false")
+	t.Setenv("LOG_FILE_MAX_SIZE", "100")
+	t.Setenv("LOG_FILE_MAX_BACKUPS", "3")
+	config, warnings = ConfigFromEnv()
+	assert.Equal(t, Config{
+		Level:              zapcore.WarnLevel,
+		Encoding:           EncodingConsole,
+		OutputPaths:        []string{"stdout", "/var/log/driverlocation.log"},
+		SamplingInitial:    0,
+		SamplingThereafter: 100,
+		MaxSize:            100,
+		MaxBackups:         3,
+	}, config)
+	assert.Empty(t, warnings)
+
+	t.Setenv("LOG_LEVEL", "verbose")
+	t.Setenv("LOG_ENCODING", "xml")
+	t.Setenv("LOG_SAMPLING_INITIAL", "-1")
+	t.Setenv("LOG_CALLER", "maybe")
+	config, warnings = ConfigFromEnv()
+	assert.Equal(t, zapcore.DebugLevel, config.Level)
+	assert.Equal(t, EncodingJSON, config.Encoding)
+	assert.Equal(t, 100, config.SamplingInitial)
+	assert.True(t, config.Caller)
+	assert.Len(t, warnings, 4)
+}
+
+func Test_Configure(t *testing.T) {
+	defer Configure(DefaultConfig())
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.log")
+	config := DefaultConfig()
+	config.Level = zapcore.InfoLevel
+	config.Encoding = EncodingConsole
+	config.OutputPaths = []string{path}
+	config.SamplingInitial = 0
+	config.Caller = false
+	assert.Nil(t, Configure(config))
+
+	Debug("not logged")
+	Info("logged")
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, strings.Count(string(content), "\n"))
+	assert.Contains(t, string(content), "info\tlogged")
+
+	config.Encoding = "xml"
+	assert.NotNil(t, Configure(config))
+}
+
+func Test_Configure_ContextLogger(t *testing.T) {
+	defer Configure(DefaultConfig())
+
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.OutputPaths = []string{filepath.Join(dir, "first.log")}
+	config.SamplingInitial = 0
+	assert.Nil(t, Configure(config))
+
+	ctx := WithContext(context.Background(), "request_id", "req-1")
+	FromContext(ctx).Info("first")
+
+	// loggers of contexts write to the sinks of the new config
+	config.OutputPaths = []string{filepath.Join(dir, "second.log")}
+	assert.Nil(t, Configure(config))
+	FromContext(ctx).Info("second")
+
+	first, err := ioutil.ReadFile(filepath.Join(dir, "first.log"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(first), `"msg":"first"`)
+	assert.NotContains(t, string(first), `"msg":"second"`)
+
+	second, err := ioutil.ReadFile(filepath.Join(dir, "second.log"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(second), `"msg":"second","request_id":"req-1"`)
+}
+
+func Test_Configure_Rotation(t *testing.T) {
+	defer Configure(DefaultConfig())
+
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.OutputPaths = []string{filepath.Join(dir, "test.log")}
+	config.SamplingInitial = 0
+	config.MaxSize = 1
+	config.MaxBackups = 1
+	assert.Nil(t, Configure(config))
+
+	// more than a megabyte
+	message := strings.Repeat("a", 1024)
+	for i := 0; i < 1200; i++ {
+		Info(message)
+	}
+
+	logs, err := filepath.Glob(filepath.Join(dir, "test*.log"))
+	assert.Nil(t, err)
+	assert.Len(t, logs, 2)
+}
+
+func Test_Configure_Sampling(t *testing.T) {
+	defer Configure(DefaultConfig())
+
+	path := filepath.Join(t.TempDir(), "test.log")
+	config := DefaultConfig()
+	config.OutputPaths = []string{path}
+	assert.Nil(t, Configure(config))
+
+	// entries of the access logger are not sampled
+	for i := 0; i < 300; i++ {
+		Info("sampled")
+		AccessFromContext(context.Background()).Info("request")
+	}
+
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Less(t, strings.Count(string(content), `"msg":"sampled"`), 300)
+	assert.Equal(t, 300, strings.Count(string(content), `"msg":"request"`))
+	assert.Contains(t, string(content), `"logger":"access"`)
+}
+
+func Test_LevelHandler(t *testing.T) {
+	defer Configure(DefaultConfig())
+
+	w := httptest.NewRecorder()
+	LevelHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/log/level", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"level":"debug"}`, strings.TrimSpace(w.Body.String()))
+
+	w = httptest.NewRecorder()
+	LevelHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/admin/log/level", strings.NewReader(`{"level":"error"}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, GetLogger().Core().Enabled(zapcore.WarnLevel))
+	assert.True(t, GetLogger().Core().Enabled(zapcore.ErrorLevel))
+
+	// the level is kept by the reconfigured logger
+	config := DefaultConfig()
+	config.Level = zapcore.InfoLevel
+	assert.Nil(t, Configure(config))
+	LevelHandler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/admin/log/level", strings.NewReader(`{"level":"warn"}`)))
+	assert.False(t, GetLogger().Core().Enabled(zapcore.InfoLevel))
+}
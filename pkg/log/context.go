@@ -0,0 +1,58 @@
+package log
+
+import (
+	"context"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Fields of context loggers
+const (
+	RequestIDKey = "request_id"
+	TraceIDKey   = "trace_id"
+	SpanIDKey    = "span_id"
+	UserKey      = "user"
+	RouteKey     = "route"
+)
+
+type loggerKey struct{}
+
+// WithContext returns a copy of ctx whose logger has the key-value pairs in
+// addition to the fields of the logger of ctx, they are treated as they are
+// in zap.SugaredLogger.With.
+func WithContext(ctx context.Context, keysAndValues ...interface{}) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logFromContext(ctx).With(keysAndValues...))
+}
+
+// FromContext returns the logger of ctx with the trace and span ids of the
+// span of ctx and the chi route pattern of the request of ctx, it is the
+// global logger when ctx has no logger.
+func FromContext(ctx context.Context) *zap.SugaredLogger {
+	l := logFromContext(ctx)
+	// fields of With are encoded immediately, the route is known after
+	// chi routed the request
+	if rctx := chi.RouteContext(ctx); rctx != nil {
+		if route := rctx.RoutePattern(); route != "" {
+			l = l.With(RouteKey, route)
+		}
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		l = l.With(TraceIDKey, span.TraceID().String(), SpanIDKey, span.SpanID().String())
+	}
+	return l
+}
+
+// AccessFromContext returns the logger of ctx named AccessLogger, its entries
+// are not sampled.
+func AccessFromContext(ctx context.Context) *zap.SugaredLogger {
+	return FromContext(ctx).Named(AccessLogger)
+}
+
+func logFromContext(ctx context.Context) *zap.SugaredLogger {
+	if l, ok := ctx.Value(loggerKey{}).(*zap.SugaredLogger); ok {
+		return l
+	}
+	return contextLog
+}
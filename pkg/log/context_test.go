@@ -0,0 +1,44 @@
+package log
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func Test_FromContext(t *testing.T) {
+	defer func(l *zap.SugaredLogger) { contextLog = l }(contextLog)
+	core, logs := observer.New(zapcore.DebugLevel)
+	contextLog = zap.New(core).Sugar()
+
+	ctx := context.Background()
+	FromContext(ctx).Info("no fields")
+
+	ctx = WithContext(ctx, RequestIDKey, "req-1")
+	ctx = WithContext(ctx, UserKey, "driver-1")
+	ctx = trace.ContextWithSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{1},
+		SpanID:  trace.SpanID{2},
+	}))
+	rctx := chi.NewRouteContext()
+	rctx.RoutePatterns = []string{"/api/v1/*", "/find_nearest"}
+	ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+	FromContext(ctx).Info("fields")
+
+	entries := logs.AllUntimed()
+	assert.Len(t, entries, 2)
+	assert.Empty(t, entries[0].ContextMap())
+	assert.Equal(t, map[string]interface{}{
+		RequestIDKey: "req-1",
+		UserKey:      "driver-1",
+		TraceIDKey:   "01000000000000000000000000000000",
+		SpanIDKey:    "0200000000000000",
+		RouteKey:     "/api/v1/find_nearest",
+	}, entries[1].ContextMap())
+}
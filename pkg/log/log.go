@@ -1,51 +1,38 @@
 package log
 
 import (
-	"time"
-
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
 )
 
 var log *zap.SugaredLogger
-var config zap.Config
 var logger *zap.Logger
 
+// contextLog is the logger of contexts without one, its callers are not
+// wrapped by the functions of this package.
+var contextLog *zap.SugaredLogger
+
 func init() {
 	BuildLogger()
 }
 
-// BuildLogger builds log config
+// BuildLogger builds the logger from the environment, see ConfigFromEnv
 func BuildLogger() {
-	var outputPaths []string
-	var level zapcore.Level
-
-	outputPaths = []string{"stdout"}
-	level = zapcore.DebugLevel
-
-	config = zap.Config{
-		Level:       zap.NewAtomicLevelAt(level),
-		Development: false,
-		Sampling: &zap.SamplingConfig{
-			Initial:    100,
-			Thereafter: 100,
-		},
-		Encoding:         "json",
-		EncoderConfig:    zap.NewProductionEncoderConfig(),
-		OutputPaths:      outputPaths,
-		ErrorOutputPaths: []string{"stderr"},
+	config, warnings := ConfigFromEnv()
+	if err := Configure(config); err != nil {
+		panic(err)
 	}
 
-	config.EncoderConfig.TimeKey = "time"
-	config.EncoderConfig.EncodeTime = zapcore.TimeEncoderOfLayout(time.RFC3339)
-
-	l, err := config.Build(zap.AddCallerSkip(1))
-	if err != nil {
-		panic(err)
+	for _, warning := range warnings {
+		log.Warn(warning)
 	}
+}
 
+// setLogger replaces the logger of the package functions and contexts
+// without a logger
+func setLogger(l *zap.Logger) {
 	logger = l
 	log = l.Sugar()
+	contextLog = l.WithOptions(zap.AddCallerSkip(-1)).Sugar()
 }
 
 // GetLogger returns zap.logger object
@@ -0,0 +1,72 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/s3f4/locationmatcher/pkg/log"
+)
+
+// Header carries the request id between clients and services
+const Header = "X-Request-ID"
+
+// maxLength bounds the length of request ids that are propagated
+const maxLength = 128
+
+type requestIDKey struct{}
+
+// NewContext returns a copy of ctx that carries the request id
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// FromContext returns the request id of ctx
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok
+}
+
+// Middleware propagates the X-Request-ID header of a request or assigns a new
+// id, the id is sent back in the response and logged by the logger of the
+// request context.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !valid(id) {
+			id = newID()
+		}
+		w.Header().Set(Header, id)
+
+		ctx := log.WithContext(NewContext(r.Context(), id), log.RequestIDKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// valid reports whether id may be propagated, it must be a non-empty string
+// of letters, digits and -_.: up to maxLength.
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newID returns a random 128-bit hex id
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Error(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,41 @@
+package requestid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/s3f4/locationmatcher/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Middleware(t *testing.T) {
+	var id string
+	router := chi.NewRouter()
+	router.Use(Middleware)
+	router.Get("/drivers/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, _ = FromContext(r.Context())
+		assert.NotNil(t, log.FromContext(r.Context()))
+	})
+
+	// propagated
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/drivers/1", nil)
+	req.Header.Set(Header, "matching-1f2e")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "matching-1f2e", id)
+	assert.Equal(t, "matching-1f2e", w.Header().Get(Header))
+
+	// assigned
+	for _, header := range []string{"", "bad id\n", strings.Repeat("a", maxLength+1)} {
+		w = httptest.NewRecorder()
+		req = httptest.NewRequest(http.MethodGet, "/drivers/1", nil)
+		req.Header.Set(Header, header)
+		router.ServeHTTP(w, req)
+		assert.Len(t, id, 32)
+		assert.NotEqual(t, header, id)
+		assert.Equal(t, id, w.Header().Get(Header))
+	}
+}
@@ -0,0 +1,104 @@
+package shutdown
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/s3f4/locationmatcher/pkg/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+)
+
+var (
+	// Timeout is how long in-flight requests have to finish after a server
+	// stops accepting new ones, it is read from SHUTDOWN_TIMEOUT.
+	Timeout = parseDuration("SHUTDOWN_TIMEOUT", 15*time.Second)
+	// Delay is how long a server keeps serving after it reports that it is
+	// not ready so that load balancers stop routing to it, it is read from
+	// SHUTDOWN_DELAY.
+	Delay = parseDuration("SHUTDOWN_DELAY", 5*time.Second)
+)
+
+// parseDuration parses the duration in the env variable key, fallback is used
+// when it is unset or invalid.
+func parseDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		log.Warnf("invalid %s %q, %s is used", key, value, fallback)
+		return fallback
+	}
+
+	return d
+}
+
+// NotifyContext returns a copy of parent that is canceled on SIGINT or SIGTERM
+func NotifyContext(parent context.Context) (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(parent, os.Interrupt, syscall.SIGTERM)
+}
+
+// Readiness reports whether a server accepts new requests, it is ready until
+// the server starts draining.
+type Readiness struct {
+	draining int32
+}
+
+// Ready reports whether the server is not draining
+func (r *Readiness) Ready() bool {
+	return atomic.LoadInt32(&r.draining) == 0
+}
+
+// Drain marks the server as not ready
+func (r *Readiness) Drain() {
+	atomic.StoreInt32(&r.draining, 1)
+}
+
+// HTTP marks readiness as not ready, waits Delay and shuts server down.
+// Connections that are still active after Timeout are closed.
+func HTTP(server *http.Server, readiness *Readiness) error {
+	readiness.Drain()
+	time.Sleep(Delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+		return err
+	}
+
+	return nil
+}
+
+// GRPC sets every service of healthServer to NOT_SERVING, waits Delay and
+// stops server gracefully. Calls that are still running after Timeout are
+// canceled.
+func GRPC(server *grpc.Server, healthServer *health.Server) {
+	healthServer.Shutdown()
+	time.Sleep(Delay)
+
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	timer := time.NewTimer(Timeout)
+	defer timer.Stop()
+
+	select {
+	case <-stopped:
+	case <-timer.C:
+		server.Stop()
+		<-stopped
+	}
+}
@@ -0,0 +1,129 @@
+package shutdown
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// withTimings sets Delay and Timeout until the end of the test
+func withTimings(t *testing.T, delay, timeout time.Duration) {
+	oldDelay, oldTimeout := Delay, Timeout
+	Delay, Timeout = delay, timeout
+	t.Cleanup(func() {
+		Delay, Timeout = oldDelay, oldTimeout
+	})
+}
+
+// serve serves handler until the test ends
+func serve(t *testing.T, handler http.HandlerFunc) (*http.Server, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &http.Server{Handler: handler}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	return server, "http://" + listener.Addr().String()
+}
+
+func Test_parseDuration(t *testing.T) {
+	assert.Equal(t, time.Second, parseDuration("SHUTDOWN_TEST", time.Second))
+
+	t.Setenv("SHUTDOWN_TEST", "3s")
+	assert.Equal(t, 3*time.Second, parseDuration("SHUTDOWN_TEST", time.Second))
+
+	t.Setenv("SHUTDOWN_TEST", "-3s")
+	assert.Equal(t, time.Second, parseDuration("SHUTDOWN_TEST", time.Second))
+
+	t.Setenv("SHUTDOWN_TEST", "soon")
+	assert.Equal(t, time.Second, parseDuration("SHUTDOWN_TEST", time.Second))
+}
+
+func Test_HTTP(t *testing.T) {
+	withTimings(t, 50*time.Millisecond, time.Second)
+
+	received, release := make(chan struct{}), make(chan struct{})
+	server, url := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+
+	status := make(chan int)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	<-received
+
+	var readiness Readiness
+	assert.True(t, readiness.Ready())
+
+	stopped := make(chan error)
+	go func() {
+		stopped <- HTTP(server, &readiness)
+	}()
+
+	// the in-flight request is drained
+	assert.Eventually(t, func() bool { return !readiness.Ready() }, time.Second, time.Millisecond)
+	close(release)
+	assert.Equal(t, http.StatusOK, <-status)
+	assert.Nil(t, <-stopped)
+}
+
+func Test_HTTP_Timeout(t *testing.T) {
+	withTimings(t, 0, 50*time.Millisecond)
+
+	received, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	server, url := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-release
+	})
+
+	go http.Get(url)
+	<-received
+
+	assert.Equal(t, context.DeadlineExceeded, HTTP(server, new(Readiness)))
+}
+
+func Test_GRPC(t *testing.T) {
+	withTimings(t, 0, time.Second)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := grpc.NewServer()
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("test", grpc_health_v1.HealthCheckResponse_SERVING)
+	grpc_health_v1.RegisterHealthServer(server, healthServer)
+
+	served := make(chan error)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	GRPC(server, healthServer)
+	<-served
+
+	response, err := healthServer.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: "test"})
+	assert.Nil(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, response.GetStatus())
+}
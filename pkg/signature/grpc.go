@@ -0,0 +1,61 @@
+package signature
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// grpcMethod is the method of signed gRPC calls, the full method name is
+// signed as the URI and the deterministic encoding of the request as the body.
+const grpcMethod = "GRPC"
+
+// UnaryClientInterceptor signs outgoing unary calls with s
+func UnaryClientInterceptor(s *Signer) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		body, err := messageBody(req)
+		if err != nil {
+			return err
+		}
+
+		headers, err := s.Headers(grpcMethod, method, body)
+		if err != nil {
+			return err
+		}
+
+		for key, value := range headers {
+			ctx = metadata.AppendToOutgoingContext(ctx, key, value)
+		}
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// VerifyUnary verifies the signature metadata of an incoming unary call
+func (v *Verifier) VerifyUnary(ctx context.Context, fullMethod string, req interface{}) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	header := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+
+	body, err := messageBody(req)
+	if err != nil {
+		return err
+	}
+
+	return v.Verify(grpcMethod, fullMethod, header, body)
+}
+
+func messageBody(req interface{}) ([]byte, error) {
+	message, ok := req.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a proto message", req)
+	}
+	return proto.MarshalOptions{Deterministic: true}.Marshal(message)
+}
@@ -0,0 +1,274 @@
+// Package signature signs and verifies service to service requests. A request
+// is signed with an HMAC-SHA256 of its method, URI, timestamp, nonce and body
+// digest, the key id header selects the secret so keys can be rotated.
+package signature
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/s3f4/locationmatcher/pkg/log"
+)
+
+// Headers of a signed request, they are lower case to be used as gRPC metadata
+const (
+	HeaderKeyID     = "x-service-key-id"
+	HeaderTimestamp = "x-service-timestamp"
+	HeaderNonce     = "x-service-nonce"
+	HeaderSignature = "x-service-signature"
+)
+
+// MaxSkew is the maximum age of a signed request, requests with older or
+// future timestamps are rejected and nonces are remembered for this long.
+const MaxSkew = 5 * time.Minute
+
+// MaxBodySize is the maximum body size of a verified request
+const MaxBodySize = 1 << 20
+
+var (
+	// ErrNoKeys is returned when the verifier has no keys
+	ErrNoKeys = errors.New("no service signing keys are configured")
+	// ErrMissingHeaders is returned when a request is not signed
+	ErrMissingHeaders = errors.New("request is not signed")
+	// ErrUnknownKey is returned when the key id of a request is not known
+	ErrUnknownKey = errors.New("unknown service signing key")
+	// ErrExpired is returned when the timestamp of a request is out of MaxSkew
+	ErrExpired = errors.New("signed request is expired")
+	// ErrInvalidSignature is returned when the signature does not match
+	ErrInvalidSignature = errors.New("invalid request signature")
+	// ErrReplayed is returned when the nonce of a request was already used
+	ErrReplayed = errors.New("signed request is replayed")
+)
+
+// Key is a shared secret and its id
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// ParseKeys parses comma separated id:secret pairs
+func ParseKeys(value string) ([]Key, error) {
+	var keys []Key
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid service signing key %q", parts[0])
+		}
+		keys = append(keys, Key{ID: parts[0], Secret: []byte(parts[1])})
+	}
+	return keys, nil
+}
+
+// KeysFromEnv parses SERVICE_SIGNING_KEYS, requests are signed with the first
+// key and verified with any of them.
+func KeysFromEnv() []Key {
+	keys, err := ParseKeys(os.Getenv("SERVICE_SIGNING_KEYS"))
+	if err != nil {
+		log.Errorf("parsing SERVICE_SIGNING_KEYS: %s", err)
+		return nil
+	}
+	return keys
+}
+
+// Signer signs requests with a key
+type Signer struct {
+	key Key
+	now func() time.Time
+}
+
+// NewSigner returns a Signer for key
+func NewSigner(key Key) *Signer {
+	return &Signer{key: key, now: time.Now}
+}
+
+// SignerFromEnv returns a Signer for the first key of SERVICE_SIGNING_KEYS,
+// it is nil when no key is configured.
+func SignerFromEnv() *Signer {
+	keys := KeysFromEnv()
+	if len(keys) == 0 {
+		log.Warn("SERVICE_SIGNING_KEYS is not set, service requests are not signed")
+		return nil
+	}
+	return NewSigner(keys[0])
+}
+
+// Headers returns the signature headers of a request
+func (s *Signer) Headers(method, uri string, body []byte) (map[string]string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	timestamp := strconv.FormatInt(s.now().Unix(), 10)
+	headers := map[string]string{
+		HeaderKeyID:     s.key.ID,
+		HeaderTimestamp: timestamp,
+		HeaderNonce:     hex.EncodeToString(nonce),
+	}
+	headers[HeaderSignature] = sign(s.key.Secret, method, uri, timestamp, headers[HeaderNonce], body)
+
+	return headers, nil
+}
+
+// Sign sets the signature headers of r, body is the body of r
+func (s *Signer) Sign(r *http.Request, body []byte) error {
+	headers, err := s.Headers(r.Method, r.URL.RequestURI(), body)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+	return nil
+}
+
+// Verifier verifies signed requests. Nonces are kept in memory, so replays are
+// detected per instance of a service.
+type Verifier struct {
+	keys map[string][]byte
+	now  func() time.Time
+
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewVerifier returns a Verifier that accepts requests signed with any of keys
+func NewVerifier(keys []Key) *Verifier {
+	v := &Verifier{
+		keys:   make(map[string][]byte),
+		now:    time.Now,
+		nonces: make(map[string]time.Time),
+	}
+	for _, key := range keys {
+		v.keys[key.ID] = key.Secret
+	}
+	return v
+}
+
+// Verify verifies the signature headers of a request, header returns the
+// value of a header.
+func (v *Verifier) Verify(method, uri string, header func(string) string, body []byte) error {
+	secret, err := v.secret(header)
+	if err != nil {
+		return err
+	}
+
+	keyID, timestamp := header(HeaderKeyID), header(HeaderTimestamp)
+	nonce, signature := header(HeaderNonce), header(HeaderSignature)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrMissingHeaders
+	}
+
+	now := v.now()
+	signedAt := time.Unix(seconds, 0)
+	if signedAt.Before(now.Add(-MaxSkew)) || signedAt.After(now.Add(MaxSkew)) {
+		return ErrExpired
+	}
+
+	expected := sign(secret, method, uri, timestamp, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+
+	return v.useNonce(keyID+":"+nonce, signedAt.Add(MaxSkew), now)
+}
+
+// secret returns the secret of a request, it fails when a signature header is
+// missing or the key id is not known.
+func (v *Verifier) secret(header func(string) string) ([]byte, error) {
+	if len(v.keys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	keyID, timestamp := header(HeaderKeyID), header(HeaderTimestamp)
+	nonce, signature := header(HeaderNonce), header(HeaderSignature)
+	if keyID == "" || timestamp == "" || nonce == "" || signature == "" {
+		return nil, ErrMissingHeaders
+	}
+
+	secret, ok := v.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return secret, nil
+}
+
+// VerifyRequest verifies r and restores its body for the next handlers. The
+// headers are checked before the body is read, bodies larger than MaxBodySize
+// fail.
+func (v *Verifier) VerifyRequest(r *http.Request) error {
+	if _, err := v.secret(r.Header.Get); err != nil {
+		return err
+	}
+
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, MaxBodySize))
+		if err != nil {
+			return err
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	return v.Verify(r.Method, r.URL.RequestURI(), r.Header.Get, body)
+}
+
+// useNonce records nonce until expiresAt, it fails when nonce is already used
+func (v *Verifier) useNonce(nonce string, expiresAt, now time.Time) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if now.Sub(v.lastSweep) >= MaxSkew {
+		for n, e := range v.nonces {
+			if now.After(e) {
+				delete(v.nonces, n)
+			}
+		}
+		v.lastSweep = now
+	}
+
+	if _, ok := v.nonces[nonce]; ok {
+		return ErrReplayed
+	}
+	v.nonces[nonce] = expiresAt
+
+	return nil
+}
+
+// sign returns the hex encoded HMAC-SHA256 of a request
+func sign(secret []byte, method, uri, timestamp, nonce string, body []byte) string {
+	digest := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join([]string{
+		strings.ToUpper(method),
+		uri,
+		timestamp,
+		nonce,
+		hex.EncodeToString(digest[:]),
+	}, "\n")))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,159 @@
+package signature
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+var (
+	oldKey = Key{ID: "old", Secret: []byte("old-secret")}
+	newKey = Key{ID: "new", Secret: []byte("new-secret")}
+)
+
+func header(headers map[string]string) func(string) string {
+	return func(key string) string { return headers[key] }
+}
+
+func Test_ParseKeys(t *testing.T) {
+	keys, err := ParseKeys("new:new-secret, old:old:secret,")
+	assert.Nil(t, err)
+	assert.Equal(t, []Key{{ID: "new", Secret: []byte("new-secret")}, {ID: "old", Secret: []byte("old:secret")}}, keys)
+
+	keys, err = ParseKeys("")
+	assert.Nil(t, err)
+	assert.Len(t, keys, 0)
+
+	_, err = ParseKeys("secret")
+	assert.NotNil(t, err)
+}
+
+func Test_Verify(t *testing.T) {
+	v := NewVerifier([]Key{newKey, oldKey})
+	now := time.Now()
+	v.now = func() time.Time { return now }
+
+	signer := NewSigner(newKey)
+	signer.now = v.now
+	body := []byte(`{"driver_id":"1"}`)
+
+	headers, err := signer.Headers(http.MethodPost, "/api/v1/driver_locations/", body)
+	assert.Nil(t, err)
+
+	// tampered requests are rejected before their nonce is used
+	assert.Equal(t, ErrInvalidSignature, v.Verify(http.MethodPost, "/api/v1/driver_locations/", header(headers), []byte(`{"driver_id":"2"}`)))
+	assert.Equal(t, ErrInvalidSignature, v.Verify(http.MethodPost, "/api/v1/driver_locations/find_nearest", header(headers), body))
+	assert.Equal(t, ErrInvalidSignature, v.Verify(http.MethodGet, "/api/v1/driver_locations/", header(headers), body))
+
+	assert.Nil(t, v.Verify(http.MethodPost, "/api/v1/driver_locations/", header(headers), body))
+	assert.Equal(t, ErrReplayed, v.Verify(http.MethodPost, "/api/v1/driver_locations/", header(headers), body))
+
+	// requests signed with the previous key are accepted during rotation
+	signer.key = oldKey
+	headers, err = signer.Headers(http.MethodPost, "/", nil)
+	assert.Nil(t, err)
+	assert.Nil(t, v.Verify(http.MethodPost, "/", header(headers), nil))
+
+	signer.key = Key{ID: "unknown", Secret: []byte("secret")}
+	headers, err = signer.Headers(http.MethodPost, "/", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrUnknownKey, v.Verify(http.MethodPost, "/", header(headers), nil))
+
+	signer.key = Key{ID: "new", Secret: []byte("forged")}
+	headers, err = signer.Headers(http.MethodPost, "/", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrInvalidSignature, v.Verify(http.MethodPost, "/", header(headers), nil))
+
+	// timestamps out of MaxSkew are rejected
+	signer.key = newKey
+	for _, signedAt := range []time.Time{now.Add(-MaxSkew - time.Second), now.Add(MaxSkew + time.Second)} {
+		signer.now = func() time.Time { return signedAt }
+		headers, err = signer.Headers(http.MethodPost, "/", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, ErrExpired, v.Verify(http.MethodPost, "/", header(headers), nil))
+	}
+
+	assert.Equal(t, ErrMissingHeaders, v.Verify(http.MethodPost, "/", header(nil), nil))
+	assert.Equal(t, ErrNoKeys, NewVerifier(nil).Verify(http.MethodPost, "/", header(headers), nil))
+}
+
+func Test_useNonce_Sweep(t *testing.T) {
+	v := NewVerifier([]Key{newKey})
+	now := time.Now()
+
+	assert.Nil(t, v.useNonce("nonce", now.Add(MaxSkew), now))
+	assert.Equal(t, ErrReplayed, v.useNonce("nonce", now.Add(MaxSkew), now))
+
+	// expired nonces are removed
+	later := now.Add(2 * MaxSkew)
+	assert.Nil(t, v.useNonce("other", later.Add(MaxSkew), later))
+	assert.Len(t, v.nonces, 1)
+}
+
+func Test_Sign_VerifyRequest(t *testing.T) {
+	v := NewVerifier([]Key{newKey})
+
+	req := httptest.NewRequest(http.MethodPost, "http://driverlocation:3001/api/v1/driver_locations/find_nearest?debug=true", strings.NewReader(`{}`))
+	assert.Nil(t, NewSigner(newKey).Sign(req, []byte(`{}`)))
+	assert.Nil(t, v.VerifyRequest(req))
+
+	// the query string is signed
+	req = httptest.NewRequest(http.MethodPost, "http://driverlocation:3001/api/v1/driver_locations/find_nearest", strings.NewReader(`{}`))
+	assert.Nil(t, NewSigner(newKey).Sign(req, []byte(`{}`)))
+	req.URL.RawQuery = "debug=true"
+	assert.Equal(t, ErrInvalidSignature, v.VerifyRequest(req))
+}
+
+// unreadBody fails the test when a request body is read
+type unreadBody struct{ t *testing.T }
+
+func (b unreadBody) Read([]byte) (int, error) {
+	b.t.Error("body must not be read")
+	return 0, io.EOF
+}
+
+func Test_VerifyRequest_Body(t *testing.T) {
+	v := NewVerifier([]Key{newKey})
+
+	// bodies of unsigned requests and of unknown keys are not read
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/driver_locations", unreadBody{t})
+	assert.Equal(t, ErrMissingHeaders, v.VerifyRequest(req))
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/driver_locations", unreadBody{t})
+	assert.Nil(t, NewSigner(oldKey).Sign(req, nil))
+	assert.Equal(t, ErrUnknownKey, v.VerifyRequest(req))
+
+	body := strings.Repeat("a", MaxBodySize+1)
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/driver_locations", strings.NewReader(body))
+	assert.Nil(t, NewSigner(newKey).Sign(req, []byte(body)))
+	assert.NotNil(t, v.VerifyRequest(req))
+}
+
+func Test_UnaryClientInterceptor(t *testing.T) {
+	v := NewVerifier([]Key{newKey})
+	req := wrapperspb.String("request")
+
+	var incoming context.Context
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		incoming = metadata.NewIncomingContext(ctx, md)
+		return nil
+	}
+
+	err := UnaryClientInterceptor(NewSigner(newKey))(context.Background(), "/pb.Service/Method", req, nil, nil, invoker)
+	assert.Nil(t, err)
+
+	assert.Equal(t, ErrInvalidSignature, v.VerifyUnary(incoming, "/pb.Service/Other", req))
+	assert.Equal(t, ErrInvalidSignature, v.VerifyUnary(incoming, "/pb.Service/Method", wrapperspb.String("other")))
+	assert.Nil(t, v.VerifyUnary(incoming, "/pb.Service/Method", req))
+	assert.Equal(t, ErrMissingHeaders, v.VerifyUnary(context.Background(), "/pb.Service/Method", req))
+}
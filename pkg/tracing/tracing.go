@@ -0,0 +1,116 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/s3f4/locationmatcher/pkg/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters of spans
+const (
+	// ExporterOTLP exports spans to the OTLP gRPC endpoint of
+	// OTEL_EXPORTER_OTLP_ENDPOINT, http endpoints are not encrypted.
+	ExporterOTLP = "otlp"
+	// ExporterStdout writes spans to stdout
+	ExporterStdout = "stdout"
+)
+
+// tracerName is the instrumentation name of the spans of Middleware
+const tracerName = "github.com/s3f4/locationmatcher/pkg/tracing"
+
+var (
+	// Exporter is ExporterOTLP or ExporterStdout, tracing is disabled
+	// otherwise. It is read from OTEL_TRACES_EXPORTER.
+	Exporter = os.Getenv("OTEL_TRACES_EXPORTER")
+)
+
+// Init installs the W3C trace context propagator and, unless tracing is
+// disabled, a tracer provider that exports the spans of service. The
+// returned function flushes and stops the exporter.
+func Init(ctx context.Context, service string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	exporter, err := newExporter(ctx, Exporter)
+	if err != nil || exporter == nil {
+		return func(context.Context) error { return nil }, err
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override service
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceNameKey.String(service)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// newExporter returns the exporter named by exporter, it is nil when tracing
+// is disabled.
+func newExporter(ctx context.Context, exporter string) (sdktrace.SpanExporter, error) {
+	switch exporter {
+	case ExporterOTLP:
+		return otlptracegrpc.New(ctx)
+	case ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "", "none":
+		return nil, nil
+	default:
+		log.Warnf("invalid OTEL_TRACES_EXPORTER %q, tracing is disabled", exporter)
+		return nil, nil
+	}
+}
+
+// Middleware starts a server span for every request that continues the trace
+// of its traceparent header. Spans are named by the chi route pattern, so it
+// must be used by the root router.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := otel.Tracer(tracerName).Start(ctx, "HTTP "+r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.NetAttributesFromHTTPRequest("tcp", r)...),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("", "", r)...),
+		)
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		// the route is known after chi routed the request
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			if route := rctx.RoutePattern(); route != "" {
+				span.SetName(r.Method + " " + route)
+				span.SetAttributes(semconv.HTTPRouteKey.String(route))
+			}
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(status)...)
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(status, trace.SpanKindServer))
+	})
+}
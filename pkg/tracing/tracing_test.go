@@ -0,0 +1,65 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+)
+
+func Test_Init(t *testing.T) {
+	defer func(exporter string) { Exporter = exporter }(Exporter)
+
+	Exporter = ""
+	shutdown, err := Init(context.Background(), "test")
+	assert.Nil(t, err)
+	assert.Nil(t, shutdown(context.Background()))
+
+	Exporter = "jaeger"
+	_, err = Init(context.Background(), "test")
+	assert.Nil(t, err)
+
+	Exporter = ExporterStdout
+	shutdown, err = Init(context.Background(), "test")
+	assert.Nil(t, err)
+	assert.Nil(t, shutdown(context.Background()))
+}
+
+func Test_Middleware(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	_, err := Init(context.Background(), "test")
+	assert.Nil(t, err)
+
+	router := chi.NewRouter()
+	router.Use(Middleware)
+	router.Get("/api/v1/drivers/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/drivers/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 2)
+
+	assert.Equal(t, "GET /api/v1/drivers/{id}", spans[0].Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Contains(t, spans[0].Attributes(), semconv.HTTPStatusCodeKey.Int(http.StatusInternalServerError))
+
+	assert.Equal(t, "HTTP GET", spans[1].Name())
+	assert.False(t, spans[1].Parent().IsValid())
+	assert.Equal(t, codes.Unset, spans[1].Status().Code)
+}